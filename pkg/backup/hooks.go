@@ -0,0 +1,107 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backup
+
+import (
+	"context"
+
+	api "stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
+	"stash.appscode.dev/apimachinery/client/clientset/versioned/scheme"
+	"stash.appscode.dev/stash/pkg/eventer"
+	"stash.appscode.dev/stash/pkg/hook"
+	"stash.appscode.dev/stash/pkg/status"
+
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/reference"
+)
+
+// runHooks loads restic's hook manifests, picks out the ones for phase, and
+// runs them in weight order via a hook.Executor. It is a no-op when restic
+// declares no hooks for phase. This always runs on the same leader-elected
+// goroutine as the rest of runOnceForScheduler, so only the leader replica
+// ever fires a hook.
+func (c *Controller) runHooks(restic *api.Restic, phase hook.Phase) error {
+	hooks, err := hook.Parse(restic.Spec.Hooks)
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse hooks for Restic %s/%s", restic.Namespace, restic.Name)
+	}
+	phaseHooks := hook.ForPhase(hooks, phase)
+	if len(phaseHooks) == 0 {
+		return nil
+	}
+
+	ref, rerr := reference.GetReference(scheme.Scheme, restic)
+	if rerr != nil {
+		ref = nil
+	}
+	executor := &hook.Executor{
+		Dynamic:  c.dynamicClient,
+		Mapper:   c.restMapper,
+		Checker:  status.NewChecker(c.k8sClient, c.dynamicClient, c.restMapper),
+		Recorder: c.recorder,
+	}
+	return executor.Run(context.TODO(), ref, phaseHooks)
+}
+
+// runBackupWithHooks brackets the backup (CSI snapshot and/or restic backup)
+// with pre/post-backup hooks. Post-backup hooks always run, whether the
+// backup itself succeeded or failed, each reported through its own event so
+// the two failure modes are never conflated in the invoker's event history.
+func (c *Controller) runBackupWithHooks(restic *api.Restic, repository *api.Repository) error {
+	if err := c.runHooks(restic, hook.PhasePreBackup); err != nil {
+		c.recordResticEvent(restic, core.EventTypeWarning, eventer.EventReasonHookFailed, err.Error())
+		return err
+	}
+
+	backupErr := c.runBackup(restic, repository)
+
+	if hookErr := c.runHooks(restic, hook.PhasePostBackup); hookErr != nil {
+		if backupErr == nil {
+			c.recordResticEvent(restic, core.EventTypeWarning, eventer.EventReasonHookFailed, hookErr.Error())
+			return hookErr
+		}
+		c.recordResticEvent(restic, core.EventTypeWarning, eventer.EventReasonHookFailed, hookErr.Error())
+		return backupErr
+	}
+	return backupErr
+}
+
+// recordResticEvent is the shared event helper for the hook, CSI
+// VolumeSnapshot and missed-schedule events this package emits on a Restic,
+// so they all resolve the same ObjectReference the same way.
+func (c *Controller) recordResticEvent(restic *api.Restic, eventType, reason, msg string) {
+	ref, rerr := reference.GetReference(scheme.Scheme, restic)
+	if rerr != nil {
+		return
+	}
+	c.recorder.Event(ref, eventType, reason, msg)
+}
+
+// runBackup takes the CSI VolumeSnapshot when restic asks for one, then runs
+// the restic file-level backup unless the target is configured for
+// VolumeSnapshotModeSnapshotOnly.
+func (c *Controller) runBackup(restic *api.Restic, repository *api.Repository) error {
+	runResticBackup, err := c.runVolumeSnapshotIfRequested(restic, repository)
+	if err != nil {
+		return err
+	}
+	if !runResticBackup {
+		return nil
+	}
+	return c.runResticBackup(restic, repository)
+}
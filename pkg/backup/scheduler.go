@@ -57,7 +57,8 @@ func (c *Controller) BackupScheduler() error {
 }
 
 func (c *Controller) setupAndRunScheduler(stopBackup <-chan struct{}) error {
-	if restic, _, err := c.setup(); err != nil {
+	restic, _, err := c.setup()
+	if err != nil {
 		err = fmt.Errorf("failed to setup backup. Error: %v", err)
 		if restic != nil {
 			ref, rerr := reference.GetReference(scheme.Scheme, restic)
@@ -77,6 +78,14 @@ func (c *Controller) setupAndRunScheduler(stopBackup <-chan struct{}) error {
 		return err
 	}
 	c.initResticWatcher() // setup restic watcher, not required for offline backup
+
+	// Fire at most one catch-up run for any schedule we missed while this
+	// replica wasn't the leader (or wasn't running at all), instead of
+	// silently resuming on the next regular tick as if nothing happened.
+	if err := c.catchUpMissedSchedule(restic); err != nil {
+		klog.Errorf("failed to check for a missed schedule for Restic %s/%s: %v", restic.Namespace, restic.Name, err)
+	}
+
 	go c.runScheduler(stopBackup)
 	return nil
 }
@@ -145,15 +154,7 @@ func (c *Controller) configureScheduler(r *api.Restic) error {
 		c.cron.Remove(v.ID)
 	}
 	_, err := c.cron.AddFunc(r.Spec.Schedule, func() {
-		if err := c.runOnceForScheduler(); err != nil {
-			ref, rerr := reference.GetReference(scheme.Scheme, r)
-			if rerr == nil {
-				c.recorder.Event(ref, core.EventTypeWarning, eventer.EventReasonFailedCronJob, err.Error())
-			} else {
-				klog.Errorf("Failed to write event on %s %s. Reason: %s", r.Kind, r.Name, rerr)
-			}
-			klog.Errorln(err)
-		}
+		c.runScheduledOnce(r)
 	})
 	if err != nil {
 		return err
@@ -167,15 +168,34 @@ func (c *Controller) configureScheduler(r *api.Restic) error {
 	return err
 }
 
-func (c *Controller) runOnceForScheduler() error {
+// runOnceForScheduler runs one backup for the Restic. It waits for c.locked
+// rather than dropping the tick when busy, so a ConcurrencyPolicy of Allow
+// queues behind the run in progress instead of being silently skipped. A
+// ConcurrencyPolicy of Replace cancels ctx for whichever run is currently
+// queued or in progress; that run then either gives up waiting for the lock
+// here, or observes ctx.Err() at one of the checkpoints below and returns
+// early, freeing the lock for the tick that superseded it. Either way the
+// superseding tick still gets to run, rather than racing an unchanged
+// drop-on-busy gate and potentially losing the backup outright.
+//
+// Replace is best-effort once a run is past the last checkpoint below: ctx is
+// never passed into runBackupWithHooks/resticCLI, so a backup already in
+// progress runs to completion rather than being preempted mid-restic-command.
+// The superseding tick still queues behind it at c.locked and runs next; it
+// just doesn't cut the in-progress one short.
+func (c *Controller) runOnceForScheduler(ctx context.Context) error {
 	select {
 	case <-c.locked:
 		klog.Infof("Acquired lock for Restic %s/%s", c.opt.Namespace, c.opt.ResticName)
 		defer func() {
 			c.locked <- struct{}{}
 		}()
-	default:
-		klog.Warningf("Skipping backup schedule for Restic %s/%s", c.opt.Namespace, c.opt.ResticName)
+	case <-ctx.Done():
+		klog.Infof("Dropping superseded backup run for Restic %s/%s", c.opt.Namespace, c.opt.ResticName)
+		return nil
+	}
+	if ctx.Err() != nil {
+		klog.Infof("Dropping superseded backup run for Restic %s/%s", c.opt.Namespace, c.opt.ResticName)
 		return nil
 	}
 
@@ -206,9 +226,23 @@ func (c *Controller) runOnceForScheduler() error {
 	if err != nil {
 		return err
 	}
+	if ctx.Err() != nil {
+		klog.Infof("Dropping superseded backup run for Restic %s/%s", c.opt.Namespace, c.opt.ResticName)
+		return nil
+	}
+
+	// run pre/post-backup hooks around the CSI VolumeSnapshot and/or restic
+	// backup; post-backup hooks fire whether that backup succeeds or fails.
+	// Not ctx-aware: a Replace here runs to completion rather than being cut
+	// short (see the doc comment above).
+	backupErr := c.runBackupWithHooks(restic, repository)
+
+	if err := c.recordScheduleRun(repository, backupErr == nil); err != nil {
+		klog.Errorf("failed to record schedule run on Repository %s/%s: %v", repository.Namespace, repository.Name, err)
+	}
+	c.pruneHistory(restic)
 
-	// run final restic backup command
-	return c.runResticBackup(restic, repository)
+	return backupErr
 }
 
 func (c *Controller) checkOnceForScheduler() (err error) {
@@ -0,0 +1,237 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backup
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	api "stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
+	"stash.appscode.dev/apimachinery/client/clientset/versioned/scheme"
+	"stash.appscode.dev/stash/pkg/eventer"
+
+	"github.com/pkg/errors"
+	cronv3 "github.com/robfig/cron/v3"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/reference"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// defaultStartingDeadline bounds how stale a missed schedule can be before
+	// we give up on a catch-up run, used when the Restic doesn't set its own
+	// startingDeadlineSeconds.
+	defaultStartingDeadline = 10 * time.Minute
+
+	// maxMissedSchedules bounds how many ticks we'll walk through computing a
+	// schedule's misses, the same safety valve Kubernetes' CronJob controller
+	// uses so a Repository whose lastScheduleTime is months stale doesn't spin
+	// the controller computing every tick since then.
+	maxMissedSchedules = 100
+
+	// defaultMaxConcurrentRuns bounds goroutine fan-out under
+	// ConcurrencyPolicyAllow; concurrent ticks still queue one at a time for
+	// c.locked since there is only one resticCLI per Controller, but the pool
+	// keeps a fast schedule from spawning unbounded goroutines queued behind it.
+	defaultMaxConcurrentRuns = 3
+)
+
+// scheduleGuard applies restic.Spec.ConcurrencyPolicy to each cron tick before
+// handing off to runOnceForScheduler. Forbid is decided here, before the
+// lock is ever touched; Allow and Replace are carried out by
+// runOnceForScheduler itself, against ctx, at the point it waits on
+// c.locked.
+type scheduleGuard struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	active int
+	sema   chan struct{}
+}
+
+func newScheduleGuard() *scheduleGuard {
+	return &scheduleGuard{sema: make(chan struct{}, defaultMaxConcurrentRuns)}
+}
+
+// schedulerGuards holds one scheduleGuard per Controller, created the first
+// time it's needed. The Controller type is assembled outside this package
+// (by cmd/), so there is no constructor here to call newScheduleGuard from;
+// keying off the Controller pointer lets the first cron tick initialize its
+// own guard instead of dereferencing an unset field.
+var schedulerGuards sync.Map // map[*Controller]*scheduleGuard
+
+func (c *Controller) guard() *scheduleGuard {
+	if g, ok := schedulerGuards.Load(c); ok {
+		return g.(*scheduleGuard)
+	}
+	g, _ := schedulerGuards.LoadOrStore(c, newScheduleGuard())
+	return g.(*scheduleGuard)
+}
+
+// runScheduledOnce is what every cron tick calls instead of
+// runOnceForScheduler directly: it applies restic's concurrency policy, then
+// runs (or skips, or supersedes) the backup accordingly.
+func (c *Controller) runScheduledOnce(restic *api.Restic) {
+	policy := restic.Spec.ConcurrencyPolicy
+	if policy == "" {
+		policy = api.ConcurrencyPolicyAllow
+	}
+
+	guard := c.guard()
+	guard.mu.Lock()
+	if policy == api.ConcurrencyPolicyForbid && guard.active > 0 {
+		guard.mu.Unlock()
+		c.recordResticEvent(restic, core.EventTypeWarning, eventer.EventReasonMissedSchedule,
+			"skipping this schedule: a previous run is still in progress and concurrencyPolicy is Forbid")
+		return
+	}
+	if policy == api.ConcurrencyPolicyReplace && guard.cancel != nil {
+		guard.cancel()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel() // this run is done either way; release ctx's resources once it returns.
+	guard.cancel = cancel
+	guard.active++
+	guard.mu.Unlock()
+
+	select {
+	case guard.sema <- struct{}{}:
+	case <-ctx.Done():
+		// superseded by a later Replace tick while still waiting for a free
+		// slot in the pool; bow out instead of waiting on a run that no
+		// longer matters.
+		klog.Infof("Dropping superseded backup run for Restic %s/%s before it reached the worker pool", c.opt.Namespace, c.opt.ResticName)
+		return
+	}
+	defer func() {
+		select {
+		case <-guard.sema:
+		default:
+		}
+		guard.mu.Lock()
+		guard.active--
+		guard.mu.Unlock()
+	}()
+
+	if err := c.runOnceForScheduler(ctx); err != nil {
+		ref, rerr := reference.GetReference(scheme.Scheme, restic)
+		if rerr == nil {
+			c.recorder.Event(ref, core.EventTypeWarning, eventer.EventReasonFailedCronJob, err.Error())
+		} else {
+			klog.Errorf("Failed to write event on %s %s. Reason: %s", restic.Kind, restic.Name, rerr)
+		}
+		klog.Errorln(err)
+	}
+}
+
+// catchUpMissedSchedule fires exactly one catch-up run if the Repository's
+// last recorded schedule time shows we missed one or more ticks since this
+// controller was last running, and the most recent miss is still within
+// startingDeadlineSeconds. It is called once, at scheduler startup.
+func (c *Controller) catchUpMissedSchedule(restic *api.Restic) error {
+	schedule, err := cronv3.ParseStandard(restic.Spec.Schedule)
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse schedule %q for Restic %s/%s", restic.Spec.Schedule, restic.Namespace, restic.Name)
+	}
+
+	repository, err := c.stashClient.StashV1alpha1().Repositories(c.opt.Namespace).Get(context.TODO(), c.opt.Workload.GetRepositoryCRDName(c.opt.PodName, c.opt.NodeName), metav1.GetOptions{})
+	if kerr.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if repository.Status.LastScheduleTime == nil {
+		return nil
+	}
+
+	deadline := defaultStartingDeadline
+	if restic.Spec.StartingDeadlineSeconds != nil {
+		deadline = time.Duration(*restic.Spec.StartingDeadlineSeconds) * time.Second
+	}
+
+	now := time.Now()
+	lastMissed, missed, tooMany := missedSchedules(schedule, repository.Status.LastScheduleTime.Time, now)
+	if tooMany {
+		c.recordResticEvent(restic, core.EventTypeWarning, eventer.EventReasonTooManyMissedSchedules,
+			fmt.Sprintf("more than %d schedules were missed since %s; skipping catch-up and resuming from the next regular tick",
+				maxMissedSchedules, repository.Status.LastScheduleTime.Time))
+		return nil
+	}
+	if missed == 0 {
+		return nil
+	}
+	if now.Sub(lastMissed) > deadline {
+		// the most recent miss is already outside the deadline; let the next
+		// regular tick pick things up instead of running a stale catch-up.
+		return nil
+	}
+
+	c.recordResticEvent(restic, core.EventTypeNormal, eventer.EventReasonMissedSchedule,
+		fmt.Sprintf("missed %d schedule(s) since %s; firing one catch-up run", missed, repository.Status.LastScheduleTime.Time))
+	c.runScheduledOnce(restic)
+	return nil
+}
+
+// missedSchedules walks schedule forward from last, counting ticks that have
+// already passed as of now. It returns the most recent missed tick, how many
+// were missed, and whether it gave up early because there were more than
+// maxMissedSchedules of them.
+func missedSchedules(schedule cronv3.Schedule, last, now time.Time) (lastMissed time.Time, missed int, tooMany bool) {
+	t := last
+	for i := 0; i < maxMissedSchedules; i++ {
+		next := schedule.Next(t)
+		if next.After(now) {
+			return lastMissed, missed, false
+		}
+		lastMissed = next
+		missed++
+		t = next
+	}
+	if schedule.Next(t).After(now) {
+		return lastMissed, missed, false
+	}
+	return lastMissed, missed, true
+}
+
+// recordScheduleRun persists the outcome of a backup run onto the Repository
+// status so the next controller startup (or the next catch-up check) has an
+// accurate lastScheduleTime to compute misses from.
+func (c *Controller) recordScheduleRun(repository *api.Repository, succeeded bool) error {
+	now := metav1.Now()
+	repository.Status.LastScheduleTime = &now
+	if succeeded {
+		repository.Status.LastSuccessfulTime = &now
+	}
+	_, err := c.stashClient.StashV1alpha1().Repositories(repository.Namespace).UpdateStatus(context.TODO(), repository, metav1.UpdateOptions{})
+	return err
+}
+
+// pruneHistory is intentionally a no-op: this legacy per-Restic scheduler
+// doesn't materialize a Job or BackupSession per tick the way the v1beta1
+// BackupConfiguration scheduler subsystem does (see pkg/scheduler), and each
+// restic run is instead recorded as a snapshot inside the restic repository
+// itself. An earlier version of this function listed and deleted
+// v1beta1.BackupSessions matched only by name, which is both pointless (this
+// Restic never owns any) and unsafe (a BackupConfiguration in the same
+// namespace happening to share the Restic's name would have its real session
+// history deleted). restic.Spec.SuccessfulJobsHistoryLimit/
+// FailedJobsHistoryLimit are accepted on the API for parity with Kubernetes
+// CronJob, but have no effect in this controller.
+func (c *Controller) pruneHistory(restic *api.Restic) {}
@@ -0,0 +1,86 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backup
+
+import (
+	"context"
+
+	api "stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
+	"stash.appscode.dev/stash/pkg/eventer"
+	"stash.appscode.dev/stash/pkg/snapshot"
+	"stash.appscode.dev/stash/pkg/util"
+
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// runVolumeSnapshotIfRequested takes a CSI VolumeSnapshot of restic's target
+// PVC when restic.Spec.VolumeSnapshot is set, recording the result on
+// repository so a later restore can recreate the VolumeSnapshotContent. It
+// reports whether the restic file-level backup that normally follows should
+// still run: a target configured for VolumeSnapshotModeSnapshotOnly skips it.
+//
+// restic.Spec.VolumeSnapshot names a single PVC (see
+// util.VolumeSnapshotOptionsForRestic); a Restic whose pod mounts more than
+// one PVC only gets a CSI snapshot of that one, while the restic backup
+// itself still covers every mounted path.
+func (c *Controller) runVolumeSnapshotIfRequested(restic *api.Restic, repository *api.Repository) (runResticBackup bool, err error) {
+	if restic.Spec.VolumeSnapshot == nil {
+		return true, nil
+	}
+
+	opt, err := util.VolumeSnapshotOptionsForRestic(restic, c.snapshotClient, restic.Name)
+	if err != nil {
+		return false, err
+	}
+	result, err := snapshot.EnsureAndWait(*opt)
+	if err != nil {
+		c.recordResticEvent(restic, core.EventTypeWarning, eventer.EventReasonSnapshotFailed, err.Error())
+		return false, errors.Wrapf(err, "failed to take CSI VolumeSnapshot for Restic %s/%s", restic.Namespace, restic.Name)
+	}
+
+	if err := c.recordVolumeSnapshotResult(repository, result); err != nil {
+		return false, errors.Wrapf(err, "failed to record VolumeSnapshot result on Repository %s/%s", repository.Namespace, repository.Name)
+	}
+
+	msg := "created CSI VolumeSnapshot"
+	if result.LocalOnly {
+		msg = "created CSI VolumeSnapshot; driver does not upload to the repository's object store, snapshot data stays in-cluster only"
+	}
+	c.recordResticEvent(restic, core.EventTypeNormal, eventer.EventReasonSnapshotCreated, msg)
+
+	return restic.Spec.VolumeSnapshot.Mode != api.VolumeSnapshotModeSnapshotOnly, nil
+}
+
+// recordVolumeSnapshotResult stamps repository's status with the outcome of
+// the most recent CSI VolumeSnapshot taken for it, including whether the data
+// actually left the cluster, so `stash describe repository` never has to
+// guess why a restore can't find an object-store blob for a given snapshot.
+func (c *Controller) recordVolumeSnapshotResult(repository *api.Repository, result *snapshot.Result) error {
+	blob, err := result.Marshal()
+	if err != nil {
+		return err
+	}
+	repository.Status.VolumeSnapshot = &api.VolumeSnapshotStatus{
+		VolumeSnapshotContentName: result.VolumeSnapshotContent.Name,
+		LocalOnly:                 result.LocalOnly,
+		Data:                      blob,
+	}
+	_, err = c.stashClient.StashV1alpha1().Repositories(repository.Namespace).UpdateStatus(context.TODO(), repository, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,160 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	cs "stash.appscode.dev/apimachinery/client/clientset/versioned"
+	"stash.appscode.dev/stash/pkg/util"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/clientcmd"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// kubeconfigSecretKey is the Secret key we expect a remote cluster's kubeconfig
+// under, matching the convention most multi-cluster operators already use.
+const kubeconfigSecretKey = "kubeconfig"
+
+// defaultClientTTL bounds how long a remote cluster's clients are cached before
+// being rebuilt from the kubeconfig Secret again, so a rotated credential is
+// picked up without requiring a controller restart.
+const defaultClientTTL = 5 * time.Minute
+
+// CachingResolver builds a util.WorkloadClients for each remote cluster from a
+// kubeconfig Secret living in the hub cluster, and caches it for TTL. A
+// background goroutine periodically health-checks cached clients and evicts ones
+// that can no longer reach their API server, so a dead remote cluster fails fast
+// on the next reconcile instead of silently serving a stale cached client.
+type CachingResolver struct {
+	Hub     util.WorkloadClients
+	HubKube kubernetes.Interface
+	TTL     time.Duration
+
+	mu    sync.Mutex
+	cache map[string]*cachedEntry
+
+	stopCh <-chan struct{}
+}
+
+type cachedEntry struct {
+	clients    util.WorkloadClients
+	kubeClient kubernetes.Interface
+	expiresAt  time.Time
+}
+
+// NewCachingResolver starts the background health-check loop and returns a
+// resolver ready to use. stopCh shuts the loop down.
+func NewCachingResolver(hub util.WorkloadClients, hubKube kubernetes.Interface, ttl time.Duration, stopCh <-chan struct{}) *CachingResolver {
+	if ttl <= 0 {
+		ttl = defaultClientTTL
+	}
+	r := &CachingResolver{
+		Hub:     hub,
+		HubKube: hubKube,
+		TTL:     ttl,
+		cache:   map[string]*cachedEntry{},
+		stopCh:  stopCh,
+	}
+	go r.healthCheckLoop()
+	return r
+}
+
+func (r *CachingResolver) ClientsFor(clusterRef *api_v1beta1.ClusterRef) (util.WorkloadClients, error) {
+	if clusterRef == nil {
+		return r.Hub, nil
+	}
+
+	key := clusterRef.Namespace + "/" + clusterRef.SecretName
+	r.mu.Lock()
+	entry, ok := r.cache[key]
+	r.mu.Unlock()
+	if ok && time.Now().Before(entry.expiresAt) {
+		return entry.clients, nil
+	}
+
+	entry, err := r.buildEntry(clusterRef)
+	if err != nil {
+		return util.WorkloadClients{}, err
+	}
+
+	r.mu.Lock()
+	r.cache[key] = entry
+	r.mu.Unlock()
+	return entry.clients, nil
+}
+
+func (r *CachingResolver) buildEntry(clusterRef *api_v1beta1.ClusterRef) (*cachedEntry, error) {
+	secret, err := r.HubKube.CoreV1().Secrets(clusterRef.Namespace).Get(context.TODO(), clusterRef.SecretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("cluster: failed to read kubeconfig secret %s/%s: %w", clusterRef.Namespace, clusterRef.SecretName, err)
+	}
+	kubeconfig, ok := secret.Data[kubeconfigSecretKey]
+	if !ok {
+		return nil, fmt.Errorf("cluster: secret %s/%s has no %q key", clusterRef.Namespace, clusterRef.SecretName, kubeconfigSecretKey)
+	}
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("cluster: invalid kubeconfig in secret %s/%s: %w", clusterRef.Namespace, clusterRef.SecretName, err)
+	}
+	kubeClient, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	stashClient, err := cs.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &cachedEntry{
+		clients: util.WorkloadClients{
+			KubeClient:  kubeClient,
+			StashClient: stashClient,
+		},
+		kubeClient: kubeClient,
+		expiresAt:  time.Now().Add(r.TTL),
+	}, nil
+}
+
+func (r *CachingResolver) healthCheckLoop() {
+	ticker := time.NewTicker(r.TTL / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stopCh:
+			return
+		case <-ticker.C:
+			r.evictUnhealthy()
+		}
+	}
+}
+
+func (r *CachingResolver) evictUnhealthy() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, entry := range r.cache {
+		if _, err := entry.kubeClient.Discovery().ServerVersion(); err != nil {
+			klog.Warningf("cluster: evicting cached client for %q, health check failed: %v", key, err)
+			delete(r.cache, key)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cluster lets a BackupTarget point at a workload running outside the
+// cluster the Stash controller itself runs in, the way migration tools such as
+// Velero already do. A BackupConfiguration target may carry a ClusterRef pointing
+// at a Secret holding a kubeconfig for the remote cluster; the check that a backup
+// target exists goes through a ClusterResolver. Sidecar injection and removal do not:
+// they only act on targets in the hub cluster.
+package cluster
+
+import (
+	"fmt"
+
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	"stash.appscode.dev/stash/pkg/util"
+)
+
+// ClusterResolver returns the clients to use for a given cluster reference. A nil
+// ClusterRef always means "the hub cluster the controller runs in".
+type ClusterResolver interface {
+	ClientsFor(clusterRef *api_v1beta1.ClusterRef) (util.WorkloadClients, error)
+}
+
+// HubResolver is the trivial ClusterResolver every controller had before remote
+// clusters existed: it only ever serves the hub's own clients, and rejects any
+// target that actually names a remote cluster.
+type HubResolver struct {
+	Hub util.WorkloadClients
+}
+
+func (r HubResolver) ClientsFor(clusterRef *api_v1beta1.ClusterRef) (util.WorkloadClients, error) {
+	if clusterRef == nil {
+		return r.Hub, nil
+	}
+	return util.WorkloadClients{}, fmt.Errorf("cluster: target references remote cluster %q but no multi-cluster resolver is configured", clusterRef.Name)
+}
@@ -0,0 +1,202 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	v1beta1_util "stash.appscode.dev/apimachinery/client/clientset/versioned/typed/stash/v1beta1/util"
+	"stash.appscode.dev/apimachinery/pkg/conditions"
+	"stash.appscode.dev/apimachinery/pkg/invoker"
+	"stash.appscode.dev/stash/pkg/eventer"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// RequeueReason identifies why a backup invoker reconcile is being retried. Each
+// reason gets its own backoff curve in the invoker's status, so a typo'd target
+// doesn't share a clock with a Repository that is one ExternalSecret sync away
+// from existing.
+type RequeueReason string
+
+const (
+	ReasonRepositoryNotFound    RequeueReason = "RepositoryNotFound"
+	ReasonBackendSecretNotFound RequeueReason = "BackendSecretNotFound"
+	ReasonBackupTargetNotFound  RequeueReason = "BackupTargetNotFound"
+)
+
+const (
+	initialRequeueBackoff = 5 * time.Second
+	maxRequeueBackoff     = 10 * time.Minute
+	// requeueBackoffEventThreshold is the delay past which we start telling the
+	// user about it; nobody needs an event for the first few short retries.
+	requeueBackoffEventThreshold = time.Minute
+)
+
+// backoffDuration doubles the delay for every prior attempt (capped at
+// maxRequeueBackoff) and adds up to 20% jitter so a fleet of invokers that failed
+// at the same instant don't all hammer the API server on the same tick.
+func backoffDuration(attempt int64) time.Duration {
+	if attempt < 0 {
+		attempt = 0
+	}
+	d := initialRequeueBackoff
+	for i := int64(0); i < attempt && d < maxRequeueBackoff; i++ {
+		d *= 2
+	}
+	if d > maxRequeueBackoff {
+		d = maxRequeueBackoff
+	}
+	return d + time.Duration(rand.Int63n(int64(d)/5+1))
+}
+
+// requeueInvoker requeues key after a per-reason backoff delay instead of the old
+// blanket 5 second retry. Once the invoker has been retried more than
+// c.MaxReconcileAttempts times for the same generation, it is transitioned to a
+// terminal Stalled condition instead of being requeued again.
+func (c *StashController) requeueInvoker(inv invoker.BackupInvoker, key string, reason RequeueReason) error {
+	if inv.TypeMeta.Kind != api_v1beta1.ResourceKindBackupConfiguration {
+		return fmt.Errorf("unable to requeue. Reason: Backup invoker %s  %s is not supported",
+			inv.TypeMeta.APIVersion,
+			inv.TypeMeta.Kind,
+		)
+	}
+
+	attempt, err := c.recordRequeueAttempt(inv, reason)
+	if err != nil {
+		return err
+	}
+	if shouldStallAfter(attempt, c.MaxReconcileAttempts) {
+		return c.markInvokerStalled(inv, reason, attempt)
+	}
+
+	// attempt counts this retry too; backoffDuration takes the number of prior ones.
+	delay := backoffDuration(attempt - 1)
+	if delay >= requeueBackoffEventThreshold && inv.ObjectRef != nil {
+		c.recorder.Eventf(inv.ObjectRef, core.EventTypeWarning, eventer.EventReasonTargetNotFoundBackoff,
+			"requeueing %s %s/%s for reason %q after %s (attempt %d)",
+			inv.TypeMeta.Kind, inv.ObjectMeta.Namespace, inv.ObjectMeta.Name, reason, delay, attempt)
+	}
+	c.bcQueue.GetQueue().AddAfter(key, delay)
+	return nil
+}
+
+// recordRequeueAttempt bumps status.retryAttempts[reason] and
+// status.lastRetryAt[reason], resetting both maps whenever the invoker's
+// generation has moved on since the last recorded attempt so an edit to the
+// BackupConfiguration always gets a fresh backoff curve.
+func (c *StashController) recordRequeueAttempt(inv invoker.BackupInvoker, reason RequeueReason) (int64, error) {
+	var attempt int64
+	_, err := v1beta1_util.UpdateBackupConfigurationStatus(
+		context.TODO(),
+		c.stashClient.StashV1beta1(),
+		inv.ObjectMeta,
+		func(in *api_v1beta1.BackupConfigurationStatus) (types.UID, *api_v1beta1.BackupConfigurationStatus) {
+			attempt = advanceRetryAttempt(in, inv.ObjectMeta.Generation, reason)
+			return inv.ObjectMeta.UID, in
+		},
+		metav1.UpdateOptions{},
+	)
+	return attempt, err
+}
+
+// advanceRetryAttempt records one retry attempt for reason against status,
+// resetting the per-generation retry bookkeeping first if generation has
+// moved on since the last recorded attempt, and returns the attempt count it
+// just recorded. Pulled out of recordRequeueAttempt's update closure so the
+// bookkeeping itself can be unit tested without a live clientset.
+func advanceRetryAttempt(status *api_v1beta1.BackupConfigurationStatus, generation int64, reason RequeueReason) int64 {
+	if status.RetryGeneration != generation {
+		status.RetryGeneration = generation
+		status.RetryAttempts = map[string]int64{}
+		status.LastRetryAt = map[string]metav1.Time{}
+	}
+	if status.RetryAttempts == nil {
+		status.RetryAttempts = map[string]int64{}
+	}
+	if status.LastRetryAt == nil {
+		status.LastRetryAt = map[string]metav1.Time{}
+	}
+	status.RetryAttempts[string(reason)]++
+	status.LastRetryAt[string(reason)] = metav1.Now()
+	return status.RetryAttempts[string(reason)]
+}
+
+// shouldStallAfter reports whether attempt has exceeded maxReconcileAttempts,
+// which disables stalling altogether when it is zero (or negative).
+func shouldStallAfter(attempt int64, maxReconcileAttempts int) bool {
+	return maxReconcileAttempts > 0 && attempt > int64(maxReconcileAttempts)
+}
+
+// markInvokerStalled records a terminal Stalled condition so the controller stops
+// requeueing this invoker until its generation changes, instead of retrying a
+// target that will plainly never show up forever.
+func (c *StashController) markInvokerStalled(inv invoker.BackupInvoker, reason RequeueReason, attempt int64) error {
+	err := conditions.SetInvokerStalledConditionToTrue(inv,
+		fmt.Errorf("exceeded max reconcile attempts (%d) for reason %q", c.MaxReconcileAttempts, reason))
+	if err != nil {
+		return err
+	}
+	if inv.ObjectRef != nil {
+		c.recorder.Eventf(inv.ObjectRef, core.EventTypeWarning, eventer.EventReasonInvokerStalled,
+			"stopped reconciling %s %s/%s after %d attempts for reason %q; edit the invoker to retry",
+			inv.TypeMeta.Kind, inv.ObjectMeta.Namespace, inv.ObjectMeta.Name, attempt, reason)
+	}
+	return nil
+}
+
+// invokerStalled reports whether inv is currently in the terminal Stalled state
+// for its current generation. A generation bump (e.g. the user fixing a typo'd
+// target) always clears it, since recordRequeueAttempt resets RetryGeneration on
+// the next reconcile.
+func (c *StashController) invokerStalled(inv invoker.BackupInvoker) bool {
+	return conditions.IsInvokerStalled(inv) && inv.Status.RetryGeneration == inv.ObjectMeta.Generation
+}
+
+// clearInvokerStalled clears a previously-set Stalled condition and resets the
+// per-reason retry bookkeeping once a reconcile actually succeeds. Without
+// this, an invoker that stalled, got fixed (generation bump lets reconcile
+// proceed again per invokerStalled above), and then reconciled successfully
+// would keep reporting Stalled=True forever, misleading kubectl wait and any
+// dashboard watching the condition.
+func (c *StashController) clearInvokerStalled(inv invoker.BackupInvoker) error {
+	if !conditions.IsInvokerStalled(inv) {
+		return nil
+	}
+	if err := conditions.SetInvokerStalledConditionToFalse(inv); err != nil {
+		return err
+	}
+	_, err := v1beta1_util.UpdateBackupConfigurationStatus(
+		context.TODO(),
+		c.stashClient.StashV1beta1(),
+		inv.ObjectMeta,
+		func(in *api_v1beta1.BackupConfigurationStatus) (types.UID, *api_v1beta1.BackupConfigurationStatus) {
+			in.RetryGeneration = inv.ObjectMeta.Generation
+			in.RetryAttempts = map[string]int64{}
+			in.LastRetryAt = map[string]metav1.Time{}
+			return inv.ObjectMeta.UID, in
+		},
+		metav1.UpdateOptions{},
+	)
+	return err
+}
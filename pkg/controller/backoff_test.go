@@ -0,0 +1,106 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+)
+
+func TestBackoffDurationDoublesUntilCap(t *testing.T) {
+	base := initialRequeueBackoff
+	for attempt := int64(0); attempt < 6; attempt++ {
+		d := backoffDuration(attempt)
+		if d < base || d > base+base/5+time.Nanosecond {
+			t.Fatalf("attempt %d: backoffDuration() = %s, want in [%s, %s]", attempt, d, base, base+base/5)
+		}
+		base *= 2
+	}
+}
+
+func TestBackoffDurationCapsAtMax(t *testing.T) {
+	for _, attempt := range []int64{10, 50, 1000} {
+		d := backoffDuration(attempt)
+		if d < maxRequeueBackoff || d > maxRequeueBackoff+maxRequeueBackoff/5+time.Nanosecond {
+			t.Fatalf("attempt %d: backoffDuration() = %s, want in [%s, %s]", attempt, d, maxRequeueBackoff, maxRequeueBackoff+maxRequeueBackoff/5)
+		}
+	}
+}
+
+func TestBackoffDurationNegativeAttemptTreatedAsZero(t *testing.T) {
+	d := backoffDuration(-1)
+	if d < initialRequeueBackoff || d > initialRequeueBackoff+initialRequeueBackoff/5+time.Nanosecond {
+		t.Fatalf("backoffDuration(-1) = %s, want in [%s, %s]", d, initialRequeueBackoff, initialRequeueBackoff+initialRequeueBackoff/5)
+	}
+}
+
+func TestAdvanceRetryAttemptPerReasonIsolation(t *testing.T) {
+	status := &api_v1beta1.BackupConfigurationStatus{}
+
+	if got := advanceRetryAttempt(status, 1, ReasonRepositoryNotFound); got != 1 {
+		t.Fatalf("first attempt for ReasonRepositoryNotFound = %d, want 1", got)
+	}
+	if got := advanceRetryAttempt(status, 1, ReasonRepositoryNotFound); got != 2 {
+		t.Fatalf("second attempt for ReasonRepositoryNotFound = %d, want 2", got)
+	}
+	// A different reason on the same generation tracks its own count and must
+	// not be bumped by, or reset, the other reason's attempts.
+	if got := advanceRetryAttempt(status, 1, ReasonBackendSecretNotFound); got != 1 {
+		t.Fatalf("first attempt for ReasonBackendSecretNotFound = %d, want 1", got)
+	}
+	if status.RetryAttempts[string(ReasonRepositoryNotFound)] != 2 {
+		t.Fatalf("ReasonRepositoryNotFound attempts changed after recording a different reason: got %d, want 2",
+			status.RetryAttempts[string(ReasonRepositoryNotFound)])
+	}
+	if status.LastRetryAt[string(ReasonBackendSecretNotFound)].IsZero() {
+		t.Fatal("LastRetryAt was not stamped for ReasonBackendSecretNotFound")
+	}
+}
+
+func TestAdvanceRetryAttemptResetsOnGenerationChange(t *testing.T) {
+	status := &api_v1beta1.BackupConfigurationStatus{}
+	advanceRetryAttempt(status, 1, ReasonBackupTargetNotFound)
+	advanceRetryAttempt(status, 1, ReasonBackupTargetNotFound)
+
+	got := advanceRetryAttempt(status, 2, ReasonBackupTargetNotFound)
+	if got != 1 {
+		t.Fatalf("attempt after generation bump = %d, want 1 (fresh backoff curve)", got)
+	}
+	if status.RetryGeneration != 2 {
+		t.Fatalf("RetryGeneration = %d, want 2", status.RetryGeneration)
+	}
+}
+
+func TestShouldStallAfter(t *testing.T) {
+	cases := []struct {
+		attempt              int64
+		maxReconcileAttempts int
+		want                 bool
+	}{
+		{attempt: 1, maxReconcileAttempts: 0, want: false},   // stalling disabled
+		{attempt: 100, maxReconcileAttempts: 0, want: false}, // stalling disabled
+		{attempt: 5, maxReconcileAttempts: 5, want: false},   // at the limit, not yet past it
+		{attempt: 6, maxReconcileAttempts: 5, want: true},    // past the limit
+	}
+	for _, c := range cases {
+		if got := shouldStallAfter(c.attempt, c.maxReconcileAttempts); got != c.want {
+			t.Fatalf("shouldStallAfter(%d, %d) = %v, want %v", c.attempt, c.maxReconcileAttempts, got, c.want)
+		}
+	}
+}
@@ -20,20 +20,16 @@ import (
 	"context"
 	"fmt"
 	"strings"
-	"time"
 
 	"stash.appscode.dev/apimachinery/apis"
 	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
 	v1beta1_util "stash.appscode.dev/apimachinery/client/clientset/versioned/typed/stash/v1beta1/util"
 	"stash.appscode.dev/apimachinery/pkg/conditions"
-	"stash.appscode.dev/apimachinery/pkg/docker"
 	"stash.appscode.dev/apimachinery/pkg/invoker"
 	"stash.appscode.dev/stash/pkg/eventer"
 	stash_rbac "stash.appscode.dev/stash/pkg/rbac"
 	"stash.appscode.dev/stash/pkg/util"
 
-	"gomodules.xyz/pointer"
-	batch_v1beta1 "k8s.io/api/batch/v1beta1"
 	core "k8s.io/api/core/v1"
 	kerr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -41,7 +37,6 @@ import (
 	"k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/klog/v2"
-	batch_util "kmodules.xyz/client-go/batch/v1beta1"
 	core_util "kmodules.xyz/client-go/core/v1"
 	meta2 "kmodules.xyz/client-go/meta"
 	"kmodules.xyz/client-go/tools/queue"
@@ -102,31 +97,19 @@ func (c *StashController) runBackupConfigurationProcessor(key string) error {
 }
 
 func (c *StashController) applyBackupInvokerReconciliationLogic(inv invoker.BackupInvoker, key string) error {
-	// check if backup invoker is being deleted. if it is being deleted then delete respective resources.
+	// check if backup invoker is being deleted. if it is being deleted then run the
+	// finalizer step-list and only drop the finalizer once every step is done.
 	if inv.ObjectMeta.DeletionTimestamp != nil {
-		if core_util.HasFinalizer(inv.ObjectMeta, api_v1beta1.StashKey) {
-			for _, targetInfo := range inv.TargetsInfo {
-				if targetInfo.Target != nil {
-					err := c.EnsureV1beta1SidecarDeleted(targetInfo.Target.Ref, inv.ObjectMeta.Namespace)
-					if err != nil {
-						return c.handleWorkloadControllerTriggerFailure(inv.ObjectRef, err)
-					}
-				}
-			}
-
-			if err := c.EnsureBackupTriggeringCronJobDeleted(inv); err != nil {
-				return err
-			}
-
-			// Ensure that the ClusterRoleBindings for this backup invoker has been deleted
-			if err := stash_rbac.EnsureClusterRoleBindingDeleted(c.kubeClient, inv.ObjectMeta, inv.Labels); err != nil {
-				return err
-			}
-			// Remove finalizer
-			return inv.RemoveFinalizer()
+		if core_util.HasFinalizer(inv.ObjectMeta, backupInvokerFinalizer) {
+			return c.finalizeBackupInvoker(inv)
 		}
 		return nil
 	}
+	// A stalled invoker stops getting requeued until its generation changes, so we
+	// don't keep hammering a target that has already been retried past the limit.
+	if c.invokerStalled(inv) {
+		return nil
+	}
 	err := inv.AddFinalizer()
 	if err != nil {
 		return err
@@ -148,7 +131,7 @@ func (c *StashController) applyBackupInvokerReconciliationLogic(inv invoker.Back
 				if err2 != nil {
 					return err2
 				}
-				return c.requeueInvoker(inv, key, 5*time.Second)
+				return c.requeueInvoker(inv, key, ReasonRepositoryNotFound)
 			}
 			err2 := conditions.SetRepositoryFoundConditionToUnknown(inv, err)
 			return errors.NewAggregate([]error{err, err2})
@@ -172,7 +155,7 @@ func (c *StashController) applyBackupInvokerReconciliationLogic(inv invoker.Back
 				if err2 != nil {
 					return err2
 				}
-				return c.requeueInvoker(inv, key, 5*time.Second)
+				return c.requeueInvoker(inv, key, ReasonBackendSecretNotFound)
 			}
 			err2 := conditions.SetBackendSecretFoundConditionToUnknown(inv, secret.Name, err)
 			return errors.NewAggregate([]error{err, err2})
@@ -187,12 +170,17 @@ func (c *StashController) applyBackupInvokerReconciliationLogic(inv invoker.Back
 	for _, targetInfo := range inv.TargetsInfo {
 		if targetInfo.Target != nil {
 			tref := targetInfo.Target.Ref
-			wc := util.WorkloadClients{
-				KubeClient:       c.kubeClient,
-				OcClient:         c.ocClient,
-				StashClient:      c.stashClient,
-				CRDClient:        c.crdClient,
-				AppCatalogClient: c.appCatalogClient,
+			wc, err := c.clusterResolver.ClientsFor(targetInfo.Target.Cluster)
+			if err != nil {
+				cerr := conditions.SetBackupTargetFoundConditionToUnknown(inv, tref, err)
+				return errors.NewAggregate([]error{err, cerr})
+			}
+			// the resolver only hands back the generic clients; OC/CRD/AppCatalog clients
+			// are only meaningful for the hub cluster today.
+			if targetInfo.Target.Cluster == nil {
+				wc.OcClient = c.ocClient
+				wc.CRDClient = c.crdClient
+				wc.AppCatalogClient = c.appCatalogClient
 			}
 			targetExist, err := wc.IsTargetExist(tref, inv.ObjectMeta.Namespace)
 			if err != nil {
@@ -227,8 +215,11 @@ func (c *StashController) applyBackupInvokerReconciliationLogic(inv invoker.Back
 			if err != nil {
 				return err
 			}
-			// For sidecar model, ensure the stash sidecar
-			if (inv.Driver == "" || inv.Driver == api_v1beta1.ResticSnapshotter) && util.BackupModel(tref.Kind) == apis.ModelSidecar {
+			// For sidecar model, ensure the stash sidecar. This still only works for
+			// hub-cluster targets: sidecar injection goes through the hub's own
+			// workload informers, which obviously can't see a remote cluster's pods.
+			if targetInfo.Target.Cluster == nil &&
+				(inv.Driver == "" || inv.Driver == api_v1beta1.ResticSnapshotter) && util.BackupModel(tref.Kind) == apis.ModelSidecar {
 				err := c.EnsureV1beta1Sidecar(tref, inv.ObjectMeta.Namespace)
 				if err != nil {
 					return c.handleWorkloadControllerTriggerFailure(inv.ObjectRef, err)
@@ -244,7 +235,7 @@ func (c *StashController) applyBackupInvokerReconciliationLogic(inv invoker.Back
 			inv.ObjectMeta.Namespace,
 			inv.ObjectMeta.Name,
 		)
-		return c.requeueInvoker(inv, key, 5*time.Second)
+		return c.requeueInvoker(inv, key, ReasonBackupTargetNotFound)
 	}
 	// create a CronJob that will create BackupSession on each schedule
 	err = c.EnsureBackupTriggeringCronJob(inv)
@@ -254,7 +245,12 @@ func (c *StashController) applyBackupInvokerReconciliationLogic(inv invoker.Back
 		return c.handleCronJobCreationFailure(inv.ObjectRef, errors.NewAggregate([]error{err, cerr}))
 	}
 	// Successfully ensured the backup triggering CronJob. So, set "CronJobCreated" condition to "True"
-	return conditions.SetCronJobCreatedConditionToTrue(inv)
+	if err := conditions.SetCronJobCreatedConditionToTrue(inv); err != nil {
+		return err
+	}
+	// Reconcile made it all the way through, so any earlier Stalled verdict no
+	// longer applies; clear it instead of leaving it stuck at True forever.
+	return c.clearInvokerStalled(inv)
 }
 
 // EnsureV1beta1SidecarDeleted send an event to workload respective controller
@@ -277,8 +273,33 @@ func (c *StashController) EnsureV1beta1Sidecar(targetRef api_v1beta1.TargetRef,
 	)
 }
 
+// sendEventToWorkloadQueue enqueues the workload controller that owns the named
+// resource. A Pod or Job doesn't have a pod template Stash can inject a sidecar
+// into, so those kinds are first resolved to their owning workload (ReplicaSet ->
+// Deployment, Job -> CronJob, ...) via util.ResolveWorkloadOwner before falling
+// through to the normal per-kind enqueue below.
 func (c *StashController) sendEventToWorkloadQueue(kind, namespace, resourceName string) error {
+	if kind == workload_api.KindPod || kind == workload_api.KindJob {
+		wc := util.WorkloadClients{KubeClient: c.kubeClient}
+		owner, err := wc.ResolveWorkloadOwner(api_v1beta1.TargetRef{Kind: kind, Name: resourceName}, namespace)
+		if err != nil {
+			return err
+		}
+		kind, resourceName = owner.Kind, owner.Name
+	}
+
 	switch kind {
+	case workload_api.KindCronJob:
+		// A CronJob's Pods come from the Jobs it creates, not from a pod
+		// template Stash's mutating webhook ever sees directly, and there is
+		// no CronJob lister/queue in this controller to enqueue into. Until
+		// that support lands (e.g. via an ephemeral-container sidecar
+		// injected into the CronJob's next Job-owned Pod), there is nothing
+		// to enqueue; log it and fall through to the same no-op return as
+		// every other kind below whose lister lookup comes up empty, instead
+		// of failing the whole reconcile over a target we simply can't act
+		// on yet.
+		klog.Warningf("sidecar injection for CronJob-owned target %s/%s is not supported yet; skipping", namespace, resourceName)
 	case workload_api.KindDeployment:
 		if resource, err := c.dpLister.Deployments(namespace).Get(resourceName); err == nil {
 			key, err := cache.MetaNamespaceKeyFunc(resource)
@@ -332,32 +353,37 @@ func (c *StashController) sendEventToWorkloadQueue(kind, namespace, resourceName
 	return nil
 }
 
-// EnsureBackupTriggeringCronJob creates a Kubernetes CronJob for the respective backup invoker
-// the CornJob will create a BackupSession object in each schedule
-// respective BackupSession controller will watch this BackupSession object and take backup instantly
+// EnsureBackupTriggeringCronJob delegates to the configured Scheduler (CronJob
+// v1beta1/v1, or the in-process goroutine scheduler - see pkg/scheduler) to make
+// sure inv fires on its schedule. The scheduler will create a BackupSession object
+// on each tick; the respective BackupSession controller watches it and takes the
+// backup instantly.
 func (c *StashController) EnsureBackupTriggeringCronJob(inv invoker.BackupInvoker) error {
-	image := docker.Docker{
-		Registry: c.DockerRegistry,
-		Image:    c.StashImage,
-		Tag:      c.StashImageTag,
-	}
-
-	meta := metav1.ObjectMeta{
-		Name:      getBackupCronJobName(inv.ObjectMeta.Name),
-		Namespace: inv.ObjectMeta.Namespace,
-		Labels:    inv.Labels,
+	// There is no admission webhook rejecting a TriggerJob that shadows the Stash
+	// container name; this reconcile-time check is the only guard in place, so it
+	// must run before every CronJob ensure rather than being treated as a backstop.
+	if err := validateTriggerJobContainers(inv.TriggerJob); err != nil {
+		return err
 	}
+	return c.scheduler.Ensure(inv)
+}
 
-	// ensure respective ClusterRole,RoleBinding,ServiceAccount etc.
-	var serviceAccountName string
-
-	if inv.RuntimeSettings.Pod != nil && inv.RuntimeSettings.Pod.ServiceAccountName != "" {
-		// ServiceAccount has been specified, so use it.
-		serviceAccountName = inv.RuntimeSettings.Pod.ServiceAccountName
-	} else {
-		// ServiceAccount hasn't been specified. so create new one with same name as BackupConfiguration object prefixed with stash-trigger.
-		serviceAccountName = meta.Name
+// EnsureBackupTriggeringCronJobDeleted tears down whatever backing resource the
+// configured Scheduler used for inv.
+func (c *StashController) EnsureBackupTriggeringCronJobDeleted(inv invoker.BackupInvoker) error {
+	return c.scheduler.Delete(inv)
+}
 
+// ensureTriggerRBAC is wired into pkg/scheduler as Deps.EnsureRBAC: it creates the
+// trigger's ServiceAccount (unless the user supplied their own) and the
+// ClusterRole/RoleBinding it needs, shared by every Scheduler implementation.
+func (c *StashController) ensureTriggerRBAC(inv invoker.BackupInvoker, serviceAccountName string) error {
+	if inv.RuntimeSettings.Pod == nil || inv.RuntimeSettings.Pod.ServiceAccountName == "" {
+		meta := metav1.ObjectMeta{
+			Name:      serviceAccountName,
+			Namespace: inv.ObjectMeta.Namespace,
+			Labels:    inv.Labels,
+		}
 		_, _, err := core_util.CreateOrPatchServiceAccount(
 			context.TODO(),
 			c.kubeClient,
@@ -372,100 +398,27 @@ func (c *StashController) EnsureBackupTriggeringCronJob(inv invoker.BackupInvoke
 			return err
 		}
 	}
+	return stash_rbac.EnsureCronJobRBAC(c.kubeClient, inv.OwnerRef, inv.ObjectMeta.Namespace, serviceAccountName, c.getBackupSessionCronJobPSPNames(), inv.Labels)
+}
 
-	// now ensure RBAC stuff for this CronJob
-	err := stash_rbac.EnsureCronJobRBAC(c.kubeClient, inv.OwnerRef, inv.ObjectMeta.Namespace, serviceAccountName, c.getBackupSessionCronJobPSPNames(), inv.Labels)
-	if err != nil {
-		return err
-	}
-
-	// if the Stash is using a private registry, then ensure the image pull secrets
-	var imagePullSecrets []core.LocalObjectReference
-	if c.ImagePullSecrets != nil {
-		imagePullSecrets, err = c.ensureImagePullSecrets(inv.ObjectMeta, inv.OwnerRef)
-		if err != nil {
-			return err
+// validateTriggerJobContainers rejects a TriggerJob spec whose extra/init containers
+// shadow the name Stash uses for its own trigger container, since CreateOrPatchCronJob
+// would otherwise silently overwrite (or be overwritten by) the Stash container. This
+// is a reconcile-time check only: there is no admission webhook validating TriggerJob,
+// so a bad spec is accepted by the API server and only rejected here, on the next
+// reconcile, rather than at the moment the user applies it.
+func validateTriggerJobContainers(triggerJob api_v1beta1.TriggerJobTemplateSpec) error {
+	for _, c := range triggerJob.ExtraContainers {
+		if c.Name == apis.StashCronJobContainer {
+			return fmt.Errorf("triggerJob.extraContainers must not use reserved container name %q", apis.StashCronJobContainer)
 		}
 	}
-	_, _, err = batch_util.CreateOrPatchCronJob(
-		context.TODO(),
-		c.kubeClient,
-		meta,
-		func(in *batch_v1beta1.CronJob) *batch_v1beta1.CronJob {
-			//set backup invoker object as cron-job owner
-			core_util.EnsureOwnerReference(&in.ObjectMeta, inv.OwnerRef)
-
-			in.Spec.Schedule = inv.Schedule
-			in.Spec.Suspend = pointer.BoolP(inv.Paused) // this ensure that the CronJob is suspended when the backup invoker is paused.
-			in.Spec.JobTemplate.Labels = core_util.UpsertMap(in.Labels, inv.Labels)
-			// ensure that job gets deleted on completion
-			in.Spec.JobTemplate.Labels[apis.KeyDeleteJobOnCompletion] = apis.AllowDeletingJobOnCompletion
-			// pass offshoot labels to the CronJob's pod
-			in.Spec.JobTemplate.Spec.Template.Labels = core_util.UpsertMap(in.Spec.JobTemplate.Spec.Template.Labels, inv.Labels)
-
-			container := core.Container{
-				Name:            apis.StashCronJobContainer,
-				ImagePullPolicy: core.PullIfNotPresent,
-				Image:           image.ToContainerImage(),
-				Args: []string{
-					"create-backupsession",
-					fmt.Sprintf("--invoker-name=%s", inv.OwnerRef.Name),
-					fmt.Sprintf("--invoker-kind=%s", inv.OwnerRef.Kind),
-				},
-			}
-			// only apply the container level runtime settings that make sense for the CronJob
-			if inv.RuntimeSettings.Container != nil {
-				container.Resources = inv.RuntimeSettings.Container.Resources
-				container.Env = inv.RuntimeSettings.Container.Env
-				container.EnvFrom = inv.RuntimeSettings.Container.EnvFrom
-				container.SecurityContext = inv.RuntimeSettings.Container.SecurityContext
-			}
-
-			in.Spec.JobTemplate.Spec.Template.Spec.Containers = core_util.UpsertContainer(
-				in.Spec.JobTemplate.Spec.Template.Spec.Containers, container)
-			in.Spec.JobTemplate.Spec.Template.Spec.RestartPolicy = core.RestartPolicyNever
-			in.Spec.JobTemplate.Spec.Template.Spec.ServiceAccountName = serviceAccountName
-			in.Spec.JobTemplate.Spec.Template.Spec.ImagePullSecrets = imagePullSecrets
-
-			// only apply the pod level runtime settings that make sense for the CronJob
-			if inv.RuntimeSettings.Pod != nil {
-				if len(inv.RuntimeSettings.Pod.ImagePullSecrets) != 0 {
-					in.Spec.JobTemplate.Spec.Template.Spec.ImagePullSecrets = inv.RuntimeSettings.Pod.ImagePullSecrets
-				}
-				if inv.RuntimeSettings.Pod.SecurityContext != nil {
-					in.Spec.JobTemplate.Spec.Template.Spec.SecurityContext = inv.RuntimeSettings.Pod.SecurityContext
-				}
-			}
-
-			return in
-		},
-		metav1.PatchOptions{},
-	)
-
-	return err
-}
-
-// EnsureBackupTriggeringCronJobDeleted ensure that the CronJob of the respective backup invoker has it as owner.
-// Kuebernetes garbage collector will take care of removing the CronJob
-func (c *StashController) EnsureBackupTriggeringCronJobDeleted(inv invoker.BackupInvoker) error {
-	cur, err := c.kubeClient.BatchV1beta1().CronJobs(inv.ObjectMeta.Namespace).Get(context.TODO(), getBackupCronJobName(inv.ObjectMeta.Name), metav1.GetOptions{})
-	if err != nil {
-		if kerr.IsNotFound(err) {
-			return nil
+	for _, c := range triggerJob.InitContainers {
+		if c.Name == apis.StashCronJobContainer {
+			return fmt.Errorf("triggerJob.initContainers must not use reserved container name %q", apis.StashCronJobContainer)
 		}
-		return err
 	}
-	_, _, err = batch_util.PatchCronJob(
-		context.TODO(),
-		c.kubeClient,
-		cur,
-		func(in *batch_v1beta1.CronJob) *batch_v1beta1.CronJob {
-			core_util.EnsureOwnerReference(&in.ObjectMeta, inv.OwnerRef)
-			return in
-		},
-		metav1.PatchOptions{},
-	)
-	return err
+	return nil
 }
 
 func getBackupCronJobName(name string) string {
@@ -516,16 +469,3 @@ func (c *StashController) handleWorkloadControllerTriggerFailure(ref *core.Objec
 	)
 	return errors.NewAggregate([]error{err, err2})
 }
-
-func (c *StashController) requeueInvoker(inv invoker.BackupInvoker, key string, delay time.Duration) error {
-	switch inv.TypeMeta.Kind {
-	case api_v1beta1.ResourceKindBackupConfiguration:
-		c.bcQueue.GetQueue().AddAfter(key, delay)
-	default:
-		return fmt.Errorf("unable to requeue. Reason: Backup invoker %s  %s is not supported",
-			inv.TypeMeta.APIVersion,
-			inv.TypeMeta.Kind,
-		)
-	}
-	return nil
-}
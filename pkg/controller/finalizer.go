@@ -0,0 +1,151 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	"stash.appscode.dev/apimachinery/pkg/conditions"
+	"stash.appscode.dev/apimachinery/pkg/invoker"
+	"stash.appscode.dev/stash/pkg/eventer"
+	stash_rbac "stash.appscode.dev/stash/pkg/rbac"
+
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// backupInvokerFinalizer is the finalizer placed on every backup invoker kind we
+// know how to clean up. The HasFinalizer check on deletion uses it; inv.AddFinalizer
+// and inv.RemoveFinalizer take no finalizer argument and do not read it.
+const backupInvokerFinalizer = api_v1beta1.StashKey
+
+// defaultFinalizerTimeout bounds how long we keep retrying cleanup steps before we
+// give up and let the object delete with a FinalizerFailed condition, so a
+// permanently broken step (e.g. a webhook blocking ServiceAccount deletes) can never
+// block deletion forever.
+const defaultFinalizerTimeout = 10 * time.Minute
+
+// finalizeStep is one idempotent unit of backup-invoker cleanup. A step must treat
+// "target already gone" as success so the whole list can be safely re-run from
+// scratch after a controller crash or a restart mid-cleanup.
+type finalizeStep struct {
+	name string
+	run  func(c *StashController, inv invoker.BackupInvoker) error
+}
+
+// backupInvokerFinalizeSteps runs in order: workload-facing resources are torn down
+// before the RBAC/identity resources they depended on, so a crash between steps
+// never leaves a sidecar or CronJob pointing at an already-deleted ServiceAccount.
+var backupInvokerFinalizeSteps = []finalizeStep{
+	{name: "DeleteSidecar", run: (*StashController).finalizeSidecars},
+	{name: "DeleteTriggerCronJob", run: (*StashController).finalizeTriggerCronJob},
+	{name: "DeleteClusterRoleBindings", run: (*StashController).finalizeClusterRoleBindings},
+	{name: "DeleteServiceAccount", run: (*StashController).finalizeServiceAccount},
+}
+
+// finalizeBackupInvoker drives inv through every registered cleanup step and only
+// removes the finalizer once all of them report their target no longer exists. Each
+// step is retried on update conflicts; every retry re-fetches the invoker from the
+// API server first (see refetchInvoker) instead of reusing the now-stale copy that
+// hit the conflict, so a step never clobbers a concurrent status/owner-ref update
+// made by another controller goroutine.
+func (c *StashController) finalizeBackupInvoker(inv invoker.BackupInvoker) error {
+	if c.finalizerDeadlineExceeded(inv) {
+		if err := conditions.SetFinalizerFailedConditionToTrue(inv, fmt.Errorf("finalizer did not complete within %s", c.finalizerTimeout())); err != nil {
+			return err
+		}
+		return inv.RemoveFinalizer()
+	}
+
+	for _, step := range backupInvokerFinalizeSteps {
+		err := retry.OnError(retry.DefaultBackoff, kerr.IsConflict, func() error {
+			current, ferr := c.refetchInvoker(inv)
+			if ferr != nil {
+				return ferr
+			}
+			return step.run(c, current)
+		})
+		if err != nil {
+			return fmt.Errorf("finalizer step %q failed for %s %s/%s: %w",
+				step.name, inv.TypeMeta.Kind, inv.ObjectMeta.Namespace, inv.ObjectMeta.Name, err)
+		}
+		if inv.ObjectRef != nil {
+			c.recorder.Eventf(inv.ObjectRef, core.EventTypeNormal, eventer.EventReasonFinalizerProgress,
+				"completed finalizer step %q", step.name)
+		}
+	}
+	return inv.RemoveFinalizer()
+}
+
+// refetchInvoker re-fetches inv's live state from the API server, keyed off its
+// type and name/namespace, so a retried finalize step runs against the object as
+// it is now rather than the copy that was already stale when it hit the conflict.
+func (c *StashController) refetchInvoker(inv invoker.BackupInvoker) (invoker.BackupInvoker, error) {
+	return invoker.ExtractBackupInvokerInfo(c.stashClient, inv.TypeMeta.Kind, inv.ObjectMeta.Name, inv.ObjectMeta.Namespace)
+}
+
+func (c *StashController) finalizerTimeout() time.Duration {
+	if c.FinalizerTimeout > 0 {
+		return c.FinalizerTimeout
+	}
+	return defaultFinalizerTimeout
+}
+
+func (c *StashController) finalizerDeadlineExceeded(inv invoker.BackupInvoker) bool {
+	return inv.ObjectMeta.DeletionTimestamp != nil &&
+		time.Since(inv.ObjectMeta.DeletionTimestamp.Time) > c.finalizerTimeout()
+}
+
+func (c *StashController) finalizeSidecars(inv invoker.BackupInvoker) error {
+	for _, targetInfo := range inv.TargetsInfo {
+		if targetInfo.Target == nil {
+			continue
+		}
+		if err := c.EnsureV1beta1SidecarDeleted(targetInfo.Target.Ref, inv.ObjectMeta.Namespace); err != nil {
+			return c.handleWorkloadControllerTriggerFailure(inv.ObjectRef, err)
+		}
+	}
+	return nil
+}
+
+func (c *StashController) finalizeTriggerCronJob(inv invoker.BackupInvoker) error {
+	return c.EnsureBackupTriggeringCronJobDeleted(inv)
+}
+
+func (c *StashController) finalizeClusterRoleBindings(inv invoker.BackupInvoker) error {
+	return stash_rbac.EnsureClusterRoleBindingDeleted(c.kubeClient, inv.ObjectMeta, inv.Labels)
+}
+
+// finalizeServiceAccount removes the ServiceAccount Stash created for the trigger
+// CronJob. It is a no-op when the user supplied their own ServiceAccount, since
+// Stash never owns that resource.
+func (c *StashController) finalizeServiceAccount(inv invoker.BackupInvoker) error {
+	if inv.RuntimeSettings.Pod != nil && inv.RuntimeSettings.Pod.ServiceAccountName != "" {
+		return nil
+	}
+	name := getBackupCronJobName(inv.ObjectMeta.Name)
+	err := c.kubeClient.CoreV1().ServiceAccounts(inv.ObjectMeta.Namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
+	if err != nil && !kerr.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hook
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"stash.appscode.dev/stash/pkg/eventer"
+	"stash.appscode.dev/stash/pkg/status"
+
+	"github.com/pkg/errors"
+	batch "k8s.io/api/batch/v1"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/record"
+)
+
+// defaultTimeout bounds how long we wait for a Job/Pod hook to finish when
+// Executor.Timeout is unset. Executor.Timeout applies to every hook the
+// Executor runs; there is no per-hook timeout setting.
+const defaultTimeout = 5 * time.Minute
+
+// Executor creates hook manifests via the dynamic client, waits on Job/Pod
+// hooks to finish using the generic status.Checker, and applies each hook's
+// delete policy, mirroring the shape of Helm's own hook engine.
+type Executor struct {
+	Dynamic  dynamic.Interface
+	Mapper   status.GVKMapper
+	Checker  *status.Checker
+	Recorder record.EventRecorder
+	Timeout  time.Duration
+}
+
+// Run executes hooks in order, stopping at (and returning) the first
+// failure. Callers running post-backup/post-restore hooks should call Run
+// regardless of whether the operation they're bracketing succeeded.
+func (e *Executor) Run(ctx context.Context, ref *core.ObjectReference, hooks []*Hook) error {
+	for _, h := range hooks {
+		if err := e.run(ctx, ref, h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Executor) run(ctx context.Context, ref *core.ObjectReference, h *Hook) error {
+	gvr, err := e.Mapper.ResourceFor(h.Object.GroupVersionKind())
+	if err != nil {
+		return errors.Wrapf(err, "failed to resolve resource for hook %s/%s", h.Object.GetNamespace(), h.Object.GetName())
+	}
+	client := e.Dynamic.Resource(gvr).Namespace(h.Object.GetNamespace())
+
+	if h.HasDeletePolicy(DeletePolicyBeforeHookCreation) {
+		if err := deleteIfExists(ctx, client, h.Object.GetName()); err != nil {
+			return errors.Wrapf(err, "failed to delete prior %s hook %s/%s before creating it again", h.Phase, h.Object.GetNamespace(), h.Object.GetName())
+		}
+	}
+
+	created, err := client.Create(ctx, h.Object, metav1.CreateOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to create %s hook %s/%s", h.Phase, h.Object.GetNamespace(), h.Object.GetName())
+	}
+	e.event(ref, core.EventTypeNormal, eventer.EventReasonHookCreated,
+		fmt.Sprintf("created %s hook %s %s/%s", h.Phase, created.GetKind(), created.GetNamespace(), created.GetName()))
+
+	if waitErr := e.waitForCompletion(ctx, created); waitErr != nil {
+		e.event(ref, core.EventTypeWarning, eventer.EventReasonHookFailed,
+			fmt.Sprintf("%s hook %s/%s failed: %v", h.Phase, created.GetNamespace(), created.GetName(), waitErr))
+		if h.HasDeletePolicy(DeletePolicyHookFailed) {
+			_ = deleteIfExists(ctx, client, created.GetName())
+		}
+		return errors.Wrapf(waitErr, "%s hook %s/%s failed", h.Phase, created.GetNamespace(), created.GetName())
+	}
+
+	if h.HasDeletePolicy(DeletePolicyHookSucceeded) {
+		if err := deleteIfExists(ctx, client, created.GetName()); err != nil {
+			return errors.Wrapf(err, "%s hook %s/%s succeeded but could not be deleted", h.Phase, created.GetNamespace(), created.GetName())
+		}
+	}
+	return nil
+}
+
+// waitForCompletion only blocks for Job/Pod hooks, converted to their typed
+// form so status.Checker can apply its normal Job/Pod readiness rules
+// (the Complete condition, the Ready condition) instead of the generic CRD
+// fallback. Every other kind (ConfigMap, Secret, ...) is done as soon as it's
+// created.
+func (e *Executor) waitForCompletion(ctx context.Context, obj *unstructured.Unstructured) error {
+	var typed runtime.Object
+	switch obj.GetKind() {
+	case "Job":
+		job := &batch.Job{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, job); err != nil {
+			return err
+		}
+		typed = job
+	case "Pod":
+		pod := &core.Pod{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, pod); err != nil {
+			return err
+		}
+		typed = pod
+	default:
+		return nil
+	}
+
+	timeout := e.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	return e.Checker.WaitForResources(ctx, timeout, []runtime.Object{typed})
+}
+
+func deleteIfExists(ctx context.Context, client dynamic.ResourceInterface, name string) error {
+	err := client.Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !kerr.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (e *Executor) event(ref *core.ObjectReference, eventType, reason, msg string) {
+	if e.Recorder == nil || ref == nil {
+		return
+	}
+	e.Recorder.Event(ref, eventType, reason, msg)
+}
@@ -0,0 +1,135 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package hook runs user-supplied Kubernetes manifests (Jobs, Pods,
+// ConfigMaps, ...) around a backup or restore, the way Helm runs chart hooks
+// around an install. A manifest opts in via the `stash.appscode.dev/hook`
+// annotation naming the phase it runs in; `stash.appscode.dev/hook-weight`
+// orders hooks within a phase, and `stash.appscode.dev/hook-delete-policy`
+// decides whether the hook resource is cleaned up afterwards.
+package hook
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	// AnnotationHook names the phase a manifest runs in. A manifest without
+	// this annotation is not a hook and is ignored by Parse.
+	AnnotationHook = "stash.appscode.dev/hook"
+	// AnnotationWeight orders hooks within a phase, ascending; ties break by
+	// resource name. Defaults to 0 when absent or unparsable.
+	AnnotationWeight = "stash.appscode.dev/hook-weight"
+	// AnnotationDeletePolicy is a comma-separated list of DeletePolicy values.
+	AnnotationDeletePolicy = "stash.appscode.dev/hook-delete-policy"
+)
+
+// Phase names a point in the backup/restore lifecycle a hook can run at.
+type Phase string
+
+const (
+	PhasePreBackup   Phase = "pre-backup"
+	PhasePostBackup  Phase = "post-backup"
+	PhasePreRestore  Phase = "pre-restore"
+	PhasePostRestore Phase = "post-restore"
+)
+
+// DeletePolicy decides when a hook resource is removed after it runs.
+type DeletePolicy string
+
+const (
+	DeletePolicyHookSucceeded      DeletePolicy = "hook-succeeded"
+	DeletePolicyHookFailed         DeletePolicy = "hook-failed"
+	DeletePolicyBeforeHookCreation DeletePolicy = "before-hook-creation"
+)
+
+// Hook is a single parsed hook manifest.
+type Hook struct {
+	Object         *unstructured.Unstructured
+	Phase          Phase
+	Weight         int
+	DeletePolicies []DeletePolicy
+}
+
+// HasDeletePolicy reports whether p is one of h's configured delete policies.
+func (h *Hook) HasDeletePolicy(p DeletePolicy) bool {
+	for _, dp := range h.DeletePolicies {
+		if dp == p {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse decodes raw hook manifests (as stored verbatim on the invoker spec)
+// into Hooks, skipping any manifest that doesn't carry the hook annotation.
+func Parse(raw []runtime.RawExtension) ([]*Hook, error) {
+	var hooks []*Hook
+	for _, r := range raw {
+		u := &unstructured.Unstructured{}
+		if err := u.UnmarshalJSON(r.Raw); err != nil {
+			return nil, errors.Wrap(err, "failed to parse hook manifest")
+		}
+		annotations := u.GetAnnotations()
+		phase := Phase(annotations[AnnotationHook])
+		if phase == "" {
+			continue
+		}
+
+		weight, _ := strconv.Atoi(annotations[AnnotationWeight])
+
+		var policies []DeletePolicy
+		for _, p := range strings.Split(annotations[AnnotationDeletePolicy], ",") {
+			p = strings.TrimSpace(p)
+			if p != "" {
+				policies = append(policies, DeletePolicy(p))
+			}
+		}
+
+		hooks = append(hooks, &Hook{
+			Object:         u,
+			Phase:          phase,
+			Weight:         weight,
+			DeletePolicies: policies,
+		})
+	}
+	return hooks, nil
+}
+
+// ForPhase returns the hooks for phase, sorted ascending by weight with a
+// stable secondary sort by name so hooks of equal weight always run in the
+// same order.
+func ForPhase(hooks []*Hook, phase Phase) []*Hook {
+	var out []*Hook
+	for _, h := range hooks {
+		if h.Phase == phase {
+			out = append(out, h)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Weight != out[j].Weight {
+			return out[i].Weight < out[j].Weight
+		}
+		return out[i].Object.GetName() < out[j].Object.GetName()
+	})
+	return out
+}
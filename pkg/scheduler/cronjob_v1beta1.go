@@ -0,0 +1,98 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"stash.appscode.dev/apimachinery/apis"
+	"stash.appscode.dev/apimachinery/pkg/invoker"
+
+	robfig_cron "github.com/robfig/cron/v3"
+	"gomodules.xyz/pointer"
+	batch_v1beta1 "k8s.io/api/batch/v1beta1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	batch_util "kmodules.xyz/client-go/batch/v1beta1"
+	core_util "kmodules.xyz/client-go/core/v1"
+)
+
+// cronJobV1Beta1Scheduler is the original implementation, kept so clusters older
+// than 1.21 (where batch/v1.CronJob isn't registered yet) keep working.
+type cronJobV1Beta1Scheduler struct {
+	deps Deps
+}
+
+func (s *cronJobV1Beta1Scheduler) Ensure(inv invoker.BackupInvoker) error {
+	serviceAccountName := triggerServiceAccountName(inv)
+	if err := s.deps.EnsureRBAC(inv, serviceAccountName); err != nil {
+		return err
+	}
+
+	meta := metav1.ObjectMeta{
+		Name:      triggerCronJobName(inv),
+		Namespace: inv.ObjectMeta.Namespace,
+		Labels:    inv.Labels,
+	}
+	_, _, err := batch_util.CreateOrPatchCronJob(
+		context.TODO(),
+		s.deps.KubeClient,
+		meta,
+		func(in *batch_v1beta1.CronJob) *batch_v1beta1.CronJob {
+			core_util.EnsureOwnerReference(&in.ObjectMeta, inv.OwnerRef)
+
+			in.Spec.Schedule = inv.Schedule
+			in.Spec.Suspend = pointer.BoolP(inv.Paused)
+			in.Spec.JobTemplate.Labels = core_util.UpsertMap(in.Labels, inv.Labels)
+			in.Spec.JobTemplate.Labels[apis.KeyDeleteJobOnCompletion] = apis.AllowDeletingJobOnCompletion
+			in.Spec.JobTemplate.Spec.Template.Labels = core_util.UpsertMap(in.Spec.JobTemplate.Spec.Template.Labels, inv.Labels)
+			in.Spec.JobTemplate.Spec.Template.Spec = triggerPodSpec(inv, s.deps, serviceAccountName)
+			return in
+		},
+		metav1.PatchOptions{},
+	)
+	return err
+}
+
+func (s *cronJobV1Beta1Scheduler) Delete(inv invoker.BackupInvoker) error {
+	name := triggerCronJobName(inv)
+	err := s.deps.KubeClient.BatchV1beta1().CronJobs(inv.ObjectMeta.Namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
+	if err != nil && !kerr.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *cronJobV1Beta1Scheduler) NextFireTime(inv invoker.BackupInvoker) (time.Time, error) {
+	return nextFireTimeFromSchedule(inv.Schedule)
+}
+
+func nextFireTimeFromSchedule(schedule string) (time.Time, error) {
+	sched, err := robfig_cron.ParseStandard(schedule)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return sched.Next(time.Now()), nil
+}
+
+func triggerServiceAccountName(inv invoker.BackupInvoker) string {
+	if inv.RuntimeSettings.Pod != nil && inv.RuntimeSettings.Pod.ServiceAccountName != "" {
+		return inv.RuntimeSettings.Pod.ServiceAccountName
+	}
+	return triggerCronJobName(inv)
+}
@@ -0,0 +1,154 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"fmt"
+	"strings"
+
+	"stash.appscode.dev/apimachinery/apis"
+	cs "stash.appscode.dev/apimachinery/client/clientset/versioned"
+	"stash.appscode.dev/apimachinery/pkg/docker"
+	"stash.appscode.dev/apimachinery/pkg/invoker"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/kubernetes"
+	core_util "kmodules.xyz/client-go/core/v1"
+	meta2 "kmodules.xyz/client-go/meta"
+)
+
+// Deps bundles everything the CronJob-backed and in-process Scheduler
+// implementations need. It intentionally takes plain clients/config instead of the
+// whole StashController to avoid an import cycle between pkg/controller and
+// pkg/scheduler.
+type Deps struct {
+	KubeClient       kubernetes.Interface
+	StashClient      cs.Interface
+	DiscoveryClient  discovery.DiscoveryInterface
+	DockerRegistry   string
+	StashImage       string
+	StashImageTag    string
+	ImagePullSecrets []core.LocalObjectReference
+
+	// EnsureRBAC ensures the ClusterRole/RoleBinding/ServiceAccount the trigger
+	// needs exist. The controller supplies a function that delegates to
+	// stash_rbac.EnsureCronJobRBAC, so the CronJob implementations keep using the
+	// existing RBAC package.
+	EnsureRBAC func(inv invoker.BackupInvoker, serviceAccountName string) error
+}
+
+// New builds the Scheduler implementation selected by kind. KindAuto resolves to
+// cronjob_v1 when the server serves batch/v1 CronJobs, falling back to
+// cronjob_v1beta1 otherwise.
+func New(kind Kind, deps Deps) (Scheduler, error) {
+	switch kind {
+	case KindCronJobV1Beta1:
+		return &cronJobV1Beta1Scheduler{deps: deps}, nil
+	case KindCronJobV1:
+		return &cronJobV1Scheduler{deps: deps}, nil
+	case KindInProcess:
+		return newInProcessScheduler(deps), nil
+	case KindAuto, "":
+		resolved, err := resolveCronJobKind(deps.DiscoveryClient)
+		if err != nil {
+			return nil, err
+		}
+		return New(resolved, deps)
+	default:
+		return nil, fmt.Errorf("scheduler: unknown kind %q", kind)
+	}
+}
+
+// resolveCronJobKind asks the API server which CronJob API it actually serves, so
+// "auto" does the right thing on both old and new clusters without the operator
+// having to track their own Kubernetes version.
+func resolveCronJobKind(dc discovery.DiscoveryInterface) (Kind, error) {
+	if dc == nil {
+		return KindCronJobV1Beta1, nil
+	}
+	resources, err := dc.ServerResourcesForGroupVersion("batch/v1")
+	if err == nil {
+		for _, r := range resources.APIResources {
+			if r.Kind == "CronJob" {
+				return KindCronJobV1, nil
+			}
+		}
+	}
+	return KindCronJobV1Beta1, nil
+}
+
+// triggerContainer builds the "create-backupsession" container shared by both
+// CronJob implementations.
+func triggerContainer(inv invoker.BackupInvoker, deps Deps) core.Container {
+	image := docker.Docker{
+		Registry: deps.DockerRegistry,
+		Image:    deps.StashImage,
+		Tag:      deps.StashImageTag,
+	}
+	container := core.Container{
+		Name:            apis.StashCronJobContainer,
+		ImagePullPolicy: core.PullIfNotPresent,
+		Image:           image.ToContainerImage(),
+		Args: []string{
+			"create-backupsession",
+			fmt.Sprintf("--invoker-name=%s", inv.OwnerRef.Name),
+			fmt.Sprintf("--invoker-kind=%s", inv.OwnerRef.Kind),
+		},
+	}
+	if inv.RuntimeSettings.Container != nil {
+		container.Resources = inv.RuntimeSettings.Container.Resources
+		container.Env = inv.RuntimeSettings.Container.Env
+		container.EnvFrom = inv.RuntimeSettings.Container.EnvFrom
+		container.SecurityContext = inv.RuntimeSettings.Container.SecurityContext
+	}
+	return container
+}
+
+// triggerPodSpec builds the PodSpec shared by both CronJob
+// implementations, including the user-declared TriggerJob extra/init containers
+// and volumes.
+func triggerPodSpec(inv invoker.BackupInvoker, deps Deps, serviceAccountName string) core.PodSpec {
+	spec := core.PodSpec{
+		Containers:         core_util.UpsertContainer(nil, triggerContainer(inv, deps)),
+		RestartPolicy:      core.RestartPolicyNever,
+		ServiceAccountName: serviceAccountName,
+		ImagePullSecrets:   deps.ImagePullSecrets,
+	}
+	for _, extra := range inv.TriggerJob.ExtraContainers {
+		spec.Containers = core_util.UpsertContainer(spec.Containers, extra)
+	}
+	for _, initC := range inv.TriggerJob.InitContainers {
+		spec.InitContainers = core_util.UpsertContainer(spec.InitContainers, initC)
+	}
+	for _, vol := range inv.TriggerJob.Volumes {
+		spec.Volumes = core_util.UpsertVolume(spec.Volumes, vol)
+	}
+	if inv.RuntimeSettings.Pod != nil {
+		if len(inv.RuntimeSettings.Pod.ImagePullSecrets) != 0 {
+			spec.ImagePullSecrets = inv.RuntimeSettings.Pod.ImagePullSecrets
+		}
+		if inv.RuntimeSettings.Pod.SecurityContext != nil {
+			spec.SecurityContext = inv.RuntimeSettings.Pod.SecurityContext
+		}
+	}
+	return spec
+}
+
+func triggerCronJobName(inv invoker.BackupInvoker) string {
+	return meta2.ValidCronJobNameWithPrefix(apis.PrefixStashTrigger, strings.ReplaceAll(inv.ObjectMeta.Name, ".", "-"))
+}
@@ -0,0 +1,113 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	api_v1beta1 "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	"stash.appscode.dev/apimachinery/pkg/invoker"
+
+	"github.com/appscode/go/crypto/rand"
+	robfig_cron "github.com/robfig/cron/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+// inProcessScheduler runs every invoker's schedule inside the controller itself
+// instead of materializing a CronJob+Pod per invoker. On each tick it creates a
+// BackupSession CR directly, which is what clusters with hundreds of
+// BackupConfigurations actually want: the per-invoker pod overhead of the
+// CronJob-backed schedulers disappears entirely.
+type inProcessScheduler struct {
+	deps Deps
+
+	cron *robfig_cron.Cron
+
+	mu      sync.Mutex
+	entries map[types.UID]robfig_cron.EntryID
+}
+
+func newInProcessScheduler(deps Deps) *inProcessScheduler {
+	s := &inProcessScheduler{
+		deps:    deps,
+		cron:    robfig_cron.New(),
+		entries: map[types.UID]robfig_cron.EntryID{},
+	}
+	s.cron.Start()
+	return s
+}
+
+func (s *inProcessScheduler) Ensure(inv invoker.BackupInvoker) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id, ok := s.entries[inv.ObjectMeta.UID]; ok {
+		s.cron.Remove(id)
+		delete(s.entries, inv.ObjectMeta.UID)
+	}
+
+	id, err := s.cron.AddFunc(inv.Schedule, func() {
+		if err := s.createBackupSession(inv); err != nil {
+			klog.Errorf("in-process scheduler: failed to create BackupSession for %s %s/%s: %v",
+				inv.TypeMeta.Kind, inv.ObjectMeta.Namespace, inv.ObjectMeta.Name, err)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("in-process scheduler: invalid schedule %q: %w", inv.Schedule, err)
+	}
+	s.entries[inv.ObjectMeta.UID] = id
+	return nil
+}
+
+func (s *inProcessScheduler) Delete(inv invoker.BackupInvoker) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id, ok := s.entries[inv.ObjectMeta.UID]; ok {
+		s.cron.Remove(id)
+		delete(s.entries, inv.ObjectMeta.UID)
+	}
+	return nil
+}
+
+func (s *inProcessScheduler) NextFireTime(inv invoker.BackupInvoker) (time.Time, error) {
+	return nextFireTimeFromSchedule(inv.Schedule)
+}
+
+func (s *inProcessScheduler) createBackupSession(inv invoker.BackupInvoker) error {
+	bs := &api_v1beta1.BackupSession{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      rand.WithUniqSuffix(inv.ObjectMeta.Name),
+			Namespace: inv.ObjectMeta.Namespace,
+			Labels:    inv.Labels,
+		},
+		Spec: api_v1beta1.BackupSessionSpec{
+			Invoker: api_v1beta1.BackupInvokerRef{
+				APIGroup: inv.TypeMeta.GroupVersionKind().Group,
+				Kind:     inv.TypeMeta.Kind,
+				Name:     inv.ObjectMeta.Name,
+			},
+		},
+	}
+	_, err := s.deps.StashClient.StashV1beta1().BackupSessions(inv.ObjectMeta.Namespace).Create(context.TODO(), bs, metav1.CreateOptions{})
+	return err
+}
@@ -0,0 +1,59 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package scheduler abstracts away how a BackupConfiguration's schedule gets turned
+// into periodic BackupSession creation. batch/v1beta1.CronJob was removed in k8s
+// 1.25, and clusters with hundreds of invokers pay real per-invoker pod overhead
+// for something as simple as "create a CR on a schedule", so Stash now supports
+// more than one Scheduler implementation side by side.
+package scheduler
+
+import (
+	"time"
+
+	"stash.appscode.dev/apimachinery/pkg/invoker"
+)
+
+// Kind identifies a Scheduler implementation.
+type Kind string
+
+const (
+	// KindCronJobV1Beta1 materializes a batch/v1beta1.CronJob. Kept for clusters
+	// older than 1.21 where the v1 API isn't registered yet.
+	KindCronJobV1Beta1 Kind = "cronjob_v1beta1"
+	// KindCronJobV1 materializes a batch/v1.CronJob, the GA API since 1.21.
+	KindCronJobV1 Kind = "cronjob_v1"
+	// KindInProcess runs the schedule inside the controller itself via
+	// robfig/cron/v3, creating BackupSession CRs directly with no per-invoker pod.
+	KindInProcess Kind = "inprocess"
+	// KindAuto picks cronjob_v1 or cronjob_v1beta1 based on what the API server
+	// actually serves.
+	KindAuto Kind = "auto"
+)
+
+// Scheduler turns a BackupInvoker's schedule into periodic BackupSession creation.
+// Implementations must be idempotent: Ensure/Delete may be called repeatedly for
+// the same invoker (e.g. on every reconcile) and must converge rather than error.
+type Scheduler interface {
+	// Ensure makes sure inv's schedule is (still) active, creating or updating
+	// whatever backing resource the implementation uses.
+	Ensure(inv invoker.BackupInvoker) error
+	// Delete tears down the backing resource for inv. It must return nil if the
+	// resource is already gone.
+	Delete(inv invoker.BackupInvoker) error
+	// NextFireTime reports when inv's schedule will next fire.
+	NextFireTime(inv invoker.BackupInvoker) (time.Time, error)
+}
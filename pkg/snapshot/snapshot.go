@@ -0,0 +1,128 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package snapshot creates Kubernetes CSI VolumeSnapshots for PVC-backed
+// backup targets and waits for the external-snapshotter to make them usable,
+// so a scheduler can record the resulting VolumeSnapshot/VolumeSnapshotContent
+// pair as a JSON blob.
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	crdv1 "github.com/kubernetes-csi/external-snapshotter/client/v6/apis/volumesnapshot/v1"
+	snapshotclientset "github.com/kubernetes-csi/external-snapshotter/client/v6/clientset/versioned"
+	"github.com/pkg/errors"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// defaultTimeout bounds how long we wait for the external-snapshotter to mark
+// a VolumeSnapshot ready to use when the target didn't configure one.
+const defaultTimeout = 10 * time.Minute
+
+const defaultPollInterval = 5 * time.Second
+
+// Options configures the CSI VolumeSnapshot taken for a single PVC as part of
+// a backup run, before (or instead of) the restic file-level backup.
+type Options struct {
+	Client     snapshotclientset.Interface
+	Namespace  string
+	PVCName    string
+	ClassName  string
+	NamePrefix string
+	Timeout    time.Duration
+}
+
+// Result is the VolumeSnapshot/VolumeSnapshotContent pair a backup run
+// records, so a later restore can recreate the VolumeSnapshotContent and bind
+// a fresh PVC to it.
+type Result struct {
+	VolumeSnapshot        *crdv1.VolumeSnapshot        `json:"volumeSnapshot"`
+	VolumeSnapshotContent *crdv1.VolumeSnapshotContent `json:"volumeSnapshotContent"`
+	// LocalOnly is true when the driver never populated a snapshotHandle on
+	// the VolumeSnapshotContent, meaning the snapshot only exists on the
+	// storage backend local to this cluster and nothing was uploaded to the
+	// repository's object store.
+	LocalOnly bool `json:"localOnly"`
+}
+
+// EnsureAndWait creates (or adopts) the VolumeSnapshot for opt.PVCName and
+// blocks until the external-snapshotter has populated status.readyToUse and
+// bound a VolumeSnapshotContent, or opt.Timeout elapses.
+func EnsureAndWait(opt Options) (*Result, error) {
+	name := opt.NamePrefix + "-" + opt.PVCName
+
+	vs, err := opt.Client.SnapshotV1().VolumeSnapshots(opt.Namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if kerr.IsNotFound(err) {
+		pvcName := opt.PVCName
+		className := opt.ClassName
+		vs, err = opt.Client.SnapshotV1().VolumeSnapshots(opt.Namespace).Create(context.TODO(), &crdv1.VolumeSnapshot{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: opt.Namespace,
+			},
+			Spec: crdv1.VolumeSnapshotSpec{
+				Source: crdv1.VolumeSnapshotSource{
+					PersistentVolumeClaimName: &pvcName,
+				},
+				VolumeSnapshotClassName: &className,
+			},
+		}, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to ensure VolumeSnapshot %s/%s", opt.Namespace, name)
+	}
+
+	timeout := opt.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	if err := wait.PollImmediate(defaultPollInterval, timeout, func() (bool, error) {
+		vs, err = opt.Client.SnapshotV1().VolumeSnapshots(opt.Namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return vs.Status != nil && vs.Status.ReadyToUse != nil && *vs.Status.ReadyToUse, nil
+	}); err != nil {
+		return nil, errors.Wrapf(err, "VolumeSnapshot %s/%s did not become ready to use", opt.Namespace, name)
+	}
+
+	if vs.Status.BoundVolumeSnapshotContentName == nil {
+		return nil, errors.Errorf("VolumeSnapshot %s/%s is ready but has no bound VolumeSnapshotContent", opt.Namespace, name)
+	}
+	vsc, err := opt.Client.SnapshotV1().VolumeSnapshotContents().Get(context.TODO(), *vs.Status.BoundVolumeSnapshotContentName, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read VolumeSnapshotContent %s", *vs.Status.BoundVolumeSnapshotContentName)
+	}
+
+	return &Result{
+		VolumeSnapshot:        vs,
+		VolumeSnapshotContent: vsc,
+		LocalOnly:             vsc.Status == nil || vsc.Status.SnapshotHandle == nil || *vsc.Status.SnapshotHandle == "",
+	}, nil
+}
+
+// Marshal serializes r as a single indented JSON blob. The backup scheduler
+// stores that blob on the Repository object's status, replacing the previous
+// run's blob; it is not written into the restic repository or the backend
+// bucket.
+func (r *Result) Marshal() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
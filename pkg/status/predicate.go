@@ -0,0 +1,105 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package status
+
+import (
+	"context"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	kutil "kmodules.xyz/client-go"
+)
+
+// Predicate is an extra readiness condition layered on top of IsReady, e.g.
+// "this Pod also has a particular sidecar container". A Predicate that
+// doesn't apply to obj's kind (e.g. HasContainer given a DaemonSet) reports
+// true rather than erroring, so a single predicate list can be reused across
+// a mixed-kind resource set: it only constrains the kinds it knows about.
+type Predicate func(obj runtime.Object) (bool, error)
+
+// HasContainer reports whether a Pod has a container named name.
+func HasContainer(name string) Predicate {
+	return func(obj runtime.Object) (bool, error) {
+		pod, ok := obj.(*core.Pod)
+		if !ok {
+			return true, nil
+		}
+		for _, c := range pod.Spec.Containers {
+			if c.Name == name {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+}
+
+// HasInitContainer reports whether a Pod has an init container named name.
+func HasInitContainer(name string) Predicate {
+	return func(obj runtime.Object) (bool, error) {
+		pod, ok := obj.(*core.Pod)
+		if !ok {
+			return true, nil
+		}
+		for _, c := range pod.Spec.InitContainers {
+			if c.Name == name {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+}
+
+// WaitForResources polls every object in objs until IsReady and every
+// predicate agree it's ready, or timeout elapses. Each object is re-fetched
+// on every poll via refresh, so it judges live status rather than the
+// snapshot objs was built from.
+func (c *Checker) WaitForResources(ctx context.Context, timeout time.Duration, objs []runtime.Object, predicates ...Predicate) error {
+	return wait.PollImmediate(kutil.RetryInterval, timeout, func() (bool, error) {
+		for _, obj := range objs {
+			ready, err := c.pollOne(ctx, obj, predicates...)
+			if err != nil || !ready {
+				return ready, err
+			}
+		}
+		return true, nil
+	})
+}
+
+func (c *Checker) pollOne(ctx context.Context, obj runtime.Object, predicates ...Predicate) (bool, error) {
+	current, err := c.refresh(ctx, obj)
+	if kerr.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	ready, err := c.IsReady(ctx, current)
+	if err != nil || !ready {
+		return ready, err
+	}
+	for _, p := range predicates {
+		ok, err := p(current)
+		if err != nil || !ok {
+			return ok, err
+		}
+	}
+	return true, nil
+}
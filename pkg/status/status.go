@@ -0,0 +1,276 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package status is a single, GVK-dispatching resource readiness checker,
+// written to replace the handful of near-identical poll loops the e2e
+// framework had grown (one per workload kind, each reimplementing the same
+// "is this thing actually ready" judgment call). It mirrors the resource
+// status checks Helm 3.5 uses to decide whether `helm install --wait` can
+// return.
+package status
+
+import (
+	"context"
+	"fmt"
+
+	apps "k8s.io/api/apps/v1"
+	batch "k8s.io/api/batch/v1"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// revisionAnnotation is stamped by the deployment controller on both the
+// Deployment and its current ReplicaSet, letting us find the ReplicaSet that
+// actually belongs to the Deployment's latest rollout without guessing from
+// timestamps.
+const revisionAnnotation = "deployment.kubernetes.io/revision"
+
+// Checker decides whether a resource is ready the same way its own
+// controller would, dispatching on the object's concrete kind. Dynamic and
+// Mapper are only consulted for CRDs; every built-in kind is checked against
+// Client directly.
+type Checker struct {
+	Client  kubernetes.Interface
+	Dynamic dynamic.Interface
+	Mapper  GVKMapper
+}
+
+// GVKMapper resolves a GroupVersionKind to the GroupVersionResource Dynamic
+// needs to fetch it. A discovery-backed meta.RESTMapper satisfies this via
+// its RESTMapping method.
+type GVKMapper interface {
+	ResourceFor(gvk schema.GroupVersionKind) (schema.GroupVersionResource, error)
+}
+
+// NewChecker returns a Checker for the built-in workload kinds. dyn and
+// mapper may be nil if the caller never passes a CRD to IsReady/WaitForResources.
+func NewChecker(client kubernetes.Interface, dyn dynamic.Interface, mapper GVKMapper) *Checker {
+	return &Checker{Client: client, Dynamic: dyn, Mapper: mapper}
+}
+
+// IsReady reports whether obj is ready by the same criteria its controller
+// uses to report success, given the latest version of obj (callers polling
+// for readiness should re-fetch before each call; WaitForResources does this
+// for you).
+func (c *Checker) IsReady(ctx context.Context, obj runtime.Object) (bool, error) {
+	switch t := obj.(type) {
+	case *apps.Deployment:
+		return c.deploymentReady(ctx, t)
+	case *apps.StatefulSet:
+		return statefulSetReady(t), nil
+	case *apps.DaemonSet:
+		return daemonSetReady(t), nil
+	case *apps.ReplicaSet:
+		return replicaSetReady(t), nil
+	case *core.Pod:
+		return podReady(t), nil
+	case *core.Service:
+		return serviceReady(t), nil
+	case *core.PersistentVolumeClaim:
+		return t.Status.Phase == core.ClaimBound, nil
+	case *batch.Job:
+		return jobComplete(t), nil
+	case *unstructured.Unstructured:
+		return crdReady(t), nil
+	default:
+		return false, fmt.Errorf("status: unsupported resource kind %T", obj)
+	}
+}
+
+// deploymentReady requires the Deployment to have observed its latest spec
+// and requires its current ReplicaSet (found via the revision annotation, not
+// just "the newest one") to have fully rolled out, honoring maxUnavailable
+// instead of demanding every replica be available at once.
+func (c *Checker) deploymentReady(ctx context.Context, d *apps.Deployment) (bool, error) {
+	if d.Generation > d.Status.ObservedGeneration {
+		return false, nil
+	}
+	rs, err := c.currentReplicaSet(ctx, d)
+	if err != nil {
+		return false, err
+	}
+	if rs == nil {
+		return false, nil
+	}
+
+	replicas := int32(1)
+	if d.Spec.Replicas != nil {
+		replicas = *d.Spec.Replicas
+	}
+	maxUnavailable := 0
+	if d.Spec.Strategy.RollingUpdate != nil && d.Spec.Strategy.RollingUpdate.MaxUnavailable != nil {
+		if mu, err := intstr.GetScaledValueFromIntOrPercent(d.Spec.Strategy.RollingUpdate.MaxUnavailable, int(replicas), true); err == nil {
+			maxUnavailable = mu
+		}
+	}
+	minAvailable := replicas - int32(maxUnavailable)
+
+	return rs.Status.Replicas == replicas &&
+		d.Status.UpdatedReplicas >= replicas &&
+		d.Status.AvailableReplicas >= minAvailable, nil
+}
+
+// currentReplicaSet returns the ReplicaSet owned by d whose revision
+// annotation matches d's own, i.e. the ReplicaSet for d's latest rollout.
+func (c *Checker) currentReplicaSet(ctx context.Context, d *apps.Deployment) (*apps.ReplicaSet, error) {
+	revision := d.Annotations[revisionAnnotation]
+	rsList, err := c.Client.AppsV1().ReplicaSets(d.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.Set(d.Spec.Selector.MatchLabels).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range rsList.Items {
+		rs := &rsList.Items[i]
+		if !ownedBy(rs.OwnerReferences, d.UID) {
+			continue
+		}
+		if rs.Annotations[revisionAnnotation] == revision {
+			return rs, nil
+		}
+	}
+	return nil, nil
+}
+
+func ownedBy(owners []metav1.OwnerReference, uid types.UID) bool {
+	for _, owner := range owners {
+		if owner.Controller != nil && *owner.Controller && owner.UID == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// daemonSetReady additionally requires UpdatedNumberScheduled to have caught
+// up to DesiredNumberScheduled during a rolling update, so we don't report
+// ready while old-revision Pods are still being replaced one at a time.
+func daemonSetReady(ds *apps.DaemonSet) bool {
+	ready := ds.Status.DesiredNumberScheduled == ds.Status.NumberReady
+	if ds.Spec.UpdateStrategy.Type == apps.RollingUpdateDaemonSetStrategyType {
+		ready = ready && ds.Status.UpdatedNumberScheduled == ds.Status.DesiredNumberScheduled
+	}
+	return ready
+}
+
+// statefulSetReady requires the current and update revisions to have
+// converged, not just readyReplicas to match, so a StatefulSet mid-rollout
+// with N old-revision Pods still Ready isn't mistaken for done.
+func statefulSetReady(ss *apps.StatefulSet) bool {
+	replicas := int32(1)
+	if ss.Spec.Replicas != nil {
+		replicas = *ss.Spec.Replicas
+	}
+	return ss.Status.CurrentRevision == ss.Status.UpdateRevision && ss.Status.ReadyReplicas == replicas
+}
+
+func replicaSetReady(rs *apps.ReplicaSet) bool {
+	replicas := int32(1)
+	if rs.Spec.Replicas != nil {
+		replicas = *rs.Spec.Replicas
+	}
+	return rs.Status.ReadyReplicas == replicas
+}
+
+func podReady(pod *core.Pod) bool {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == core.PodReady {
+			return cond.Status == core.ConditionTrue
+		}
+	}
+	return false
+}
+
+// serviceReady only has a meaningful readiness notion for LoadBalancer
+// Services; every other type is ready as soon as it exists.
+func serviceReady(svc *core.Service) bool {
+	if svc.Spec.Type != core.ServiceTypeLoadBalancer {
+		return true
+	}
+	return len(svc.Status.LoadBalancer.Ingress) > 0
+}
+
+func jobComplete(job *batch.Job) bool {
+	for _, cond := range job.Status.Conditions {
+		if cond.Type == batch.JobComplete && cond.Status == core.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// crdReady looks for a standard "Ready" or "Available" condition in
+// status.conditions, the convention most CRD controllers already follow.
+func crdReady(u *unstructured.Unstructured) bool {
+	conditions, found, err := unstructured.NestedSlice(u.Object, "status", "conditions")
+	if err != nil || !found {
+		return false
+	}
+	for _, c := range conditions {
+		cond, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		condType, _ := cond["type"].(string)
+		condStatus, _ := cond["status"].(string)
+		if (condType == "Ready" || condType == "Available") && condStatus == "True" {
+			return true
+		}
+	}
+	return false
+}
+
+// refresh re-fetches obj from the API server so repeated IsReady calls in a
+// poll loop always judge the latest status instead of a stale snapshot.
+func (c *Checker) refresh(ctx context.Context, obj runtime.Object) (runtime.Object, error) {
+	switch t := obj.(type) {
+	case *apps.Deployment:
+		return c.Client.AppsV1().Deployments(t.Namespace).Get(ctx, t.Name, metav1.GetOptions{})
+	case *apps.StatefulSet:
+		return c.Client.AppsV1().StatefulSets(t.Namespace).Get(ctx, t.Name, metav1.GetOptions{})
+	case *apps.DaemonSet:
+		return c.Client.AppsV1().DaemonSets(t.Namespace).Get(ctx, t.Name, metav1.GetOptions{})
+	case *apps.ReplicaSet:
+		return c.Client.AppsV1().ReplicaSets(t.Namespace).Get(ctx, t.Name, metav1.GetOptions{})
+	case *core.Pod:
+		return c.Client.CoreV1().Pods(t.Namespace).Get(ctx, t.Name, metav1.GetOptions{})
+	case *core.Service:
+		return c.Client.CoreV1().Services(t.Namespace).Get(ctx, t.Name, metav1.GetOptions{})
+	case *core.PersistentVolumeClaim:
+		return c.Client.CoreV1().PersistentVolumeClaims(t.Namespace).Get(ctx, t.Name, metav1.GetOptions{})
+	case *batch.Job:
+		return c.Client.BatchV1().Jobs(t.Namespace).Get(ctx, t.Name, metav1.GetOptions{})
+	case *unstructured.Unstructured:
+		return c.refreshCRD(ctx, t)
+	default:
+		return nil, fmt.Errorf("status: unsupported resource kind %T", obj)
+	}
+}
+
+func (c *Checker) refreshCRD(ctx context.Context, u *unstructured.Unstructured) (runtime.Object, error) {
+	gvr, err := c.Mapper.ResourceFor(u.GroupVersionKind())
+	if err != nil {
+		return nil, err
+	}
+	return c.Dynamic.Resource(gvr).Namespace(u.GetNamespace()).Get(ctx, u.GetName(), metav1.GetOptions{})
+}
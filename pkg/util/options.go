@@ -17,10 +17,14 @@ limitations under the License.
 package util
 
 import (
+	"fmt"
+
 	api_v1alpha1 "stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
 	api "stash.appscode.dev/apimachinery/apis/stash/v1beta1"
 	"stash.appscode.dev/apimachinery/pkg/restic"
+	"stash.appscode.dev/stash/pkg/snapshot"
 
+	snapshotclientset "github.com/kubernetes-csi/external-snapshotter/client/v6/clientset/versioned"
 	go_str "gomodules.xyz/x/strings"
 )
 
@@ -108,3 +112,35 @@ func SetupOptionsForRepository(repository api_v1alpha1.Repository, extraOpt Extr
 		MaxConnections: repository.Spec.Backend.MaxConnections(),
 	}, nil
 }
+
+// VolumeSnapshotOptionsForRestic builds the CSI snapshot options for r, or nil
+// if r doesn't opt into a CSI VolumeSnapshot backup. namePrefix should be
+// stable across schedule runs for the same Restic (e.g. the Restic name) so a
+// retried run adopts the in-flight VolumeSnapshot instead of creating a second
+// one.
+//
+// restic.Spec.VolumeSnapshot names exactly one PVC by design: it is a single
+// PersistentVolumeClaim reference, not a list, so a Restic whose pod mounts
+// several PVCs only ever gets a CSI VolumeSnapshot of the one named here. The
+// restic file-level backup that runs alongside it still walks every path
+// under Spec.FileGroups regardless of which volume backs it, so this
+// restriction only affects the CSI snapshot, not restic's own backup
+// coverage. Returns an error if the PVC name is left empty instead of
+// silently handing an empty PVCName to the snapshot package.
+func VolumeSnapshotOptionsForRestic(r *api_v1alpha1.Restic, client snapshotclientset.Interface, namePrefix string) (*snapshot.Options, error) {
+	vs := r.Spec.VolumeSnapshot
+	if vs == nil {
+		return nil, nil
+	}
+	if vs.PersistentVolumeClaim.Name == "" {
+		return nil, fmt.Errorf("volumeSnapshot.persistentVolumeClaim.name must not be empty for Restic %s/%s", r.Namespace, r.Name)
+	}
+	return &snapshot.Options{
+		Client:     client,
+		Namespace:  r.Namespace,
+		PVCName:    vs.PersistentVolumeClaim.Name,
+		ClassName:  vs.ClassName,
+		NamePrefix: namePrefix,
+		Timeout:    vs.Timeout.Duration,
+	}, nil
+}
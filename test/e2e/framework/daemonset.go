@@ -21,6 +21,7 @@ import (
 	"fmt"
 
 	"stash.appscode.dev/apimachinery/apis"
+	"stash.appscode.dev/stash/pkg/status"
 
 	"github.com/appscode/go/crypto/rand"
 	. "github.com/onsi/ginkgo"
@@ -114,58 +115,42 @@ func (f *Framework) EventuallyDaemonSet(meta metav1.ObjectMeta) GomegaAsyncAsser
 }
 
 func (fi *Invocation) WaitUntilDaemonSetReadyWithSidecar(meta metav1.ObjectMeta) error {
-	return wait.PollImmediate(kutil.RetryInterval, kutil.ReadinessTimeout, func() (bool, error) {
-		if obj, err := fi.KubeClient.AppsV1().DaemonSets(meta.Namespace).Get(context.TODO(), meta.Name, metav1.GetOptions{}); err == nil {
-			if obj.Status.DesiredNumberScheduled == obj.Status.NumberReady {
-				pods, err := fi.GetAllPods(obj.ObjectMeta)
-				if err != nil {
-					return false, err
-				}
-
-				for i := range pods {
-					hasSidecar := false
-					for _, c := range pods[i].Spec.Containers {
-						if c.Name == apis.StashContainer {
-							hasSidecar = true
-						}
-					}
-					if !hasSidecar {
-						return false, nil
-					}
-				}
-				return true, nil
-			}
-			return false, nil
-		}
-		return false, nil
-	})
+	return fi.waitUntilDaemonSetReadyWithContainer(meta, status.HasContainer(apis.StashContainer))
 }
 
 func (fi *Invocation) WaitUntilDaemonSetReadyWithInitContainer(meta metav1.ObjectMeta) error {
+	return fi.waitUntilDaemonSetReadyWithContainer(meta, status.HasInitContainer(apis.StashInitContainer))
+}
+
+// waitUntilDaemonSetReadyWithContainer composes the generic status.Checker
+// readiness wait with a container-presence predicate, checked against every
+// Pod the DaemonSet owns once the DaemonSet itself reports ready.
+func (fi *Invocation) waitUntilDaemonSetReadyWithContainer(meta metav1.ObjectMeta, predicate status.Predicate) error {
+	checker := status.NewChecker(fi.KubeClient, nil, nil)
 	return wait.PollImmediate(kutil.RetryInterval, kutil.ReadinessTimeout, func() (bool, error) {
-		if obj, err := fi.KubeClient.AppsV1().DaemonSets(meta.Namespace).Get(context.TODO(), meta.Name, metav1.GetOptions{}); err == nil {
-			if obj.Status.DesiredNumberScheduled == obj.Status.NumberReady {
-				pods, err := fi.GetAllPods(obj.ObjectMeta)
-				if err != nil {
-					return false, err
-				}
-
-				for i := range pods {
-					hasInitContainer := false
-					for _, c := range pods[i].Spec.InitContainers {
-						if c.Name == apis.StashInitContainer {
-							hasInitContainer = true
-						}
-					}
-					if !hasInitContainer {
-						return false, nil
-					}
-				}
-				return true, nil
-			}
+		obj, err := fi.KubeClient.AppsV1().DaemonSets(meta.Namespace).Get(context.TODO(), meta.Name, metav1.GetOptions{})
+		if kerr.IsNotFound(err) {
 			return false, nil
 		}
-		return false, nil
+		if err != nil {
+			return false, err
+		}
+		ready, err := checker.IsReady(context.TODO(), obj)
+		if err != nil || !ready {
+			return false, err
+		}
+
+		pods, err := fi.GetAllPods(obj.ObjectMeta)
+		if err != nil {
+			return false, err
+		}
+		for i := range pods {
+			ok, err := predicate(&pods[i])
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
 	})
 }
 